@@ -0,0 +1,238 @@
+// Package api содержит типы запросов и ответов HTTP API.
+// Их используют и сервер, и клиент из пакета client.
+package api
+
+import (
+	"database/sql"
+	"time"
+)
+
+type User struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Ссылка на аватар: /avatars/<id>?v=<версия>, размер задаётся параметром size
+	Avatar string `json:"avatar"`
+	Status string `json:"status"`
+}
+
+type UserPatch struct {
+	Status *string `json:"status"`
+}
+
+type AvatarData struct {
+	Avatar string `json:"avatar"`
+}
+
+type Project struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Tasks   []Task `json:"tasks,omitempty"`
+}
+
+type NewProject struct {
+	Name   string   `json:"name"`
+	Logins []string `json:"logins"`
+}
+
+// Колонка доски. В PATCH пустые поля не меняются.
+type Column struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Место на доске, слева направо с 0
+	Position int    `json:"position"`
+	Color    string `json:"color"`
+	// todo, in_progress или done
+	Category string `json:"category"`
+}
+
+// Новое место колонки на доске, с 0
+type ColumnMove struct {
+	Position int `json:"position"`
+}
+
+// Порядок колонок доски: id всех колонок слева направо
+type ColumnOrder struct {
+	Columns []int `json:"columns"`
+}
+
+// Колонка в корзине, её можно восстановить до ExpiresAt
+type ColumnTrash struct {
+	ID     int    `json:"id"`
+	Column Column `json:"column"`
+	// Сколько задач было в колонке при удалении
+	Tasks int `json:"tasks"`
+	// Колонка, в которую перенесены задачи; 0 — задачи удалены вместе с колонкой
+	TargetColumnID int       `json:"target_column_id"`
+	DeletedAt      time.Time `json:"deleted_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// Восстановленная колонка и число вернувшихся в неё задач
+type ColumnRestore struct {
+	Column Column `json:"column"`
+	Tasks  int    `json:"tasks"`
+}
+
+type ColumnUpdate struct {
+	Old_name string `json:"old_name"`
+	New_name string `json:"new_name"`
+}
+
+type Task struct {
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Descr      sql.NullString `json:"descr"`
+	Date       string         `json:"date"`
+	Date_act   sql.NullString `json:"date_act"`
+	Empl_id    sql.NullString `json:"empl_id"`
+	Avatar     string         `json:"avatar"`
+	Project_id int            `json:"projectId"`
+	Status     string         `json:"status"`
+	Priority   sql.NullString `json:"priority"`
+	Creator_id int            `json:"creator_id"`
+	Version    int            `json:"version"`
+	// Колонка задачи; при создании можно указать вместо status
+	Column_id int `json:"column_id"`
+}
+
+type TaskResponse struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Descr    string `json:"descr"`
+	Date     string `json:"date"`
+	Date_act string `json:"date_act"`
+	Empl_id  string `json:"empl_id"`
+	// Ссылка на аватар исполнителя, пусто, если исполнителя нет
+	Avatar     string `json:"avatar"`
+	Project_id int    `json:"projectId"`
+	Status     string `json:"status"`
+	Priority   string `json:"priority"`
+	Creator_id int    `json:"creator_id"`
+	Version    int    `json:"version"`
+	Column_id  int    `json:"column_id"`
+	Files      []File `json:"files"`
+}
+
+type TaskInfo struct {
+	Name  string `json:"name"`
+	Descr string `json:"descr"`
+}
+
+type TaskPriority struct {
+	Priority string `json:"priority"`
+}
+
+type TaskChange struct {
+	From *string `json:"from"`
+	To   *string `json:"to"`
+}
+
+type TaskHistoryEntry struct {
+	ID        int                   `json:"id"`
+	TaskID    int                   `json:"task_id"`
+	ChangedAt time.Time             `json:"changed_at"`
+	Changes   map[string]TaskChange `json:"changes"`
+}
+
+type Grant struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Descr      string `json:"descr"`
+	Num        int    `json:"num"`
+	Project_id int    `json:"projectId"`
+	Version    int    `json:"version"`
+}
+
+type File struct {
+	ID       int    `json:"id"`
+	TaskID   int    `json:"task_id"`
+	Name     string `json:"name"`
+	FileUuid string `json:"file_uuid"`
+	// Тип, определённый по содержимому при загрузке
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// pending — файл ждёт проверки на вирусы и недоступен для скачивания, clean — проверен
+	ScanStatus string `json:"scan_status"`
+	// Ссылки на миниатюры картинки по наибольшей стороне в пикселях: "64", "256", "1024".
+	// Запрашиваются с заголовком X-User-ID, как и сам файл.
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	// Номер версии, начиная с 1
+	Version int `json:"version"`
+	// Сколько всего версий у файла, только в списке файлов задачи
+	Versions int `json:"versions,omitempty"`
+}
+
+// Запрос ссылки для загрузки вложения напрямую в хранилище
+type NewDirectUpload struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Content-Type, с которым клиент отправит PUT
+	ContentType string `json:"content_type"`
+	// SHA-256 содержимого в hex, сервер сверит его при подтверждении
+	SHA256 string `json:"sha256"`
+	// Id файла задачи, новой версией которого станет загрузка
+	Replaces int `json:"replaces,omitempty"`
+}
+
+type DirectUpload struct {
+	ID string `json:"id"`
+	// Файл отправляется запросом Method на UploadURL с заголовками Headers.
+	// Content-Length входит в подпись: файл другого размера хранилище не примет.
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ObjectKey string            `json:"object_key"`
+	// До этого времени загрузку нужно подтвердить, иначе объект удалится
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Место, занятое файлами проекта. Quota 0 — без ограничения.
+// Used и Files складываются из ByTask и Trash.
+type ProjectStorage struct {
+	ProjectID int           `json:"project_id"`
+	Used      int64         `json:"used"`
+	Quota     int64         `json:"quota"`
+	Files     int           `json:"files"`
+	ByTask    []TaskStorage `json:"by_task"`
+	ByType    []TypeStorage `json:"by_type"`
+	Trash     TrashStorage  `json:"trash"`
+}
+
+type TaskStorage struct {
+	TaskID int    `json:"task_id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Files  int    `json:"files"`
+}
+
+// Файлы задач, удалённых вместе с колонкой: они занимают место, пока
+// колонка лежит в корзине
+type TrashStorage struct {
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+}
+
+// Место по типу файлов, тип без параметров вроде charset
+type TypeStorage struct {
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Files       int    `json:"files"`
+}
+
+// Место, занятое файлами, которые загрузил пользователь
+type UserStorage struct {
+	UserID int   `json:"user_id"`
+	Used   int64 `json:"used"`
+	Quota  int64 `json:"quota"`
+}
+
+type Notification struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
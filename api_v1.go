@@ -0,0 +1,544 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Помечает старые маршруты как устаревшие и указывает на /api/v1
+func deprecatedRoutes() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", `</api/v1>; rel="successor-version", </docs>; rel="deprecation"`)
+		c.Next()
+	})
+}
+
+func registerV1Routes(r *gin.Engine, db *sqlx.DB, stores objectStores, uploads uploadPolicy) {
+	v1 := r.Group("/api/v1")
+
+	v1.POST("/auth/login", loginHandler(db))
+	v1.GET("/logins/:login", loginAvailabilityHandler(db))
+
+	userRoutes := v1.Group("/users")
+	{
+		userRoutes.POST("", idempotent(db), userCreateHandler(db, stores.avatars))
+		userRoutes.GET("/:id", profileHandler(db))
+		userRoutes.PATCH("/:id", userPatchHandler(db))
+		userRoutes.PUT("/:id/avatar", profileUpdateAvatarHandler(db, stores.avatars))
+		userRoutes.DELETE("/:id/avatar", avatarResetHandler(db, stores.avatars))
+		userRoutes.GET("/:id/projects", profileProjectsHandler(db))
+		userRoutes.PUT("/:id/projects/:project_id", userProjectAddHandler(db))
+		userRoutes.DELETE("/:id/projects/:project_id", profileRemoveProjectHandler(db))
+		userRoutes.GET("/:id/notifications", userNotificationsHandler(db))
+		userRoutes.GET("/:id/storage", userStorageHandler(db, uploads))
+	}
+
+	projectRoutes := v1.Group("/projects")
+	{
+		projectRoutes.GET("", projectsHandler(db))
+		projectRoutes.POST("", idempotent(db), projectCreateHandler(db))
+		projectRoutes.GET("/:id", projectHandler(db))
+		projectRoutes.PATCH("/:id", projectRenameHandler(db))
+		projectRoutes.DELETE("/:id", projectDeleteHandler(db))
+		projectRoutes.GET("/:id/storage", projectStorageHandler(db, uploads))
+
+		projectRoutes.GET("/:id/tasks", projectTasksHandler(db))
+		projectRoutes.POST("/:id/tasks", idempotent(db), taskCreateHandler(db))
+
+		projectRoutes.GET("/:id/columns", columnsHandler(db))
+		projectRoutes.POST("/:id/columns", idempotent(db), columnCreateHandler(db))
+		projectRoutes.PATCH("/:id/columns/:columnId", columnUpdateHandler(db))
+		projectRoutes.DELETE("/:id/columns/:columnId", columnDeleteHandler(db))
+		projectRoutes.POST("/:id/columns/:columnId/move", columnMoveHandler(db))
+		projectRoutes.PUT("/:id/columns/order", columnOrderHandler(db))
+		projectRoutes.GET("/:id/trash", columnTrashHandler(db))
+		projectRoutes.POST("/:id/trash/:trashId/restore", columnRestoreHandler(db))
+
+		projectRoutes.GET("/:id/members", projectMembersHandler(db))
+		projectRoutes.POST("/:id/members", idempotent(db), projectMemberAddHandler(db))
+		projectRoutes.DELETE("/:id/members/:userId", projectMemberDeleteHandler(db))
+
+		projectRoutes.GET("/:id/grants", projectGrantsHandler(db))
+		projectRoutes.POST("/:id/grants", idempotent(db), grantCreateHandler(db))
+		projectRoutes.GET("/:id/grants/:grantId", grantHandler(db))
+		projectRoutes.PATCH("/:id/grants/:grantId", grantPatchHandler(db))
+		projectRoutes.DELETE("/:id/grants/:grantId", grantDeleteHandler(db))
+	}
+
+	taskRoutes := v1.Group("/tasks")
+	{
+		taskRoutes.GET("/:id", tasksHandler(db))
+		taskRoutes.PATCH("/:id", taskPatchHandler(db))
+		taskRoutes.GET("/:id/history", taskHistoryHandler(db))
+		taskRoutes.DELETE("/:id", taskDeleteHandler(db))
+		taskRoutes.POST("/:id/files", idempotentUpload(db, uploads), taskFileCreateHandler(db, stores.files, uploads))
+		taskRoutes.GET("/:id/files/:fileId", taskFileDownloadHandler(db, stores.files))
+		taskRoutes.GET("/:id/files/:fileId/thumbnails/:size", taskFileThumbnailHandler(db, stores.files))
+		taskRoutes.DELETE("/:id/files/:fileId", taskFileDeleteHandler(db))
+		taskRoutes.GET("/:id/files/:fileId/versions", taskFileVersionsHandler(db))
+		taskRoutes.DELETE("/:id/files/:fileId/versions", taskFileVersionsPruneHandler(db))
+		taskRoutes.POST("/:id/files/:fileId/restore", taskFileRestoreHandler(db, uploads))
+		taskRoutes.OPTIONS("/:id/files/resumable", tusResumable(), resumableOptionsHandler(uploads))
+		taskRoutes.POST("/:id/files/resumable", tusResumable(), resumableCreateHandler(db, stores.files, uploads))
+		taskRoutes.POST("/:id/files/uploads", idempotent(db), directUploadCreateHandler(db, stores.files, uploads))
+		taskRoutes.POST("/:id/files/uploads/:uploadId/confirm", directUploadConfirmHandler(db, stores.files, uploads))
+	}
+
+	resumableRoutes := v1.Group("/resumable", tusResumable())
+	{
+		resumableRoutes.OPTIONS("/:uploadId", resumableOptionsHandler(uploads))
+		resumableRoutes.HEAD("/:uploadId", resumableHeadHandler(db))
+		resumableRoutes.PATCH("/:uploadId", resumablePatchHandler(db, stores.files, uploads))
+		resumableRoutes.DELETE("/:uploadId", resumableDeleteHandler(db, stores.files))
+	}
+}
+
+// Отвечает 201 Created со ссылкой на созданный ресурс
+func respondCreated(c *gin.Context, location string, body interface{}) {
+	c.Header("Location", location)
+	c.JSON(http.StatusCreated, body)
+}
+
+// GET /api/v1/logins/:login
+func loginAvailabilityHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		login := c.Param("login")
+
+		var count int
+		err := db.Get(&count, "SELECT COUNT(*) FROM users WHERE login = $1", login)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"login": login, "available": count == 0})
+	})
+}
+
+// POST /api/v1/users
+func userCreateHandler(db *sqlx.DB, avatars ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		var user User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := insertUser(db, user)
+		if err != nil {
+			if isUniqueViolation(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Login already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		createDefaultAvatar(c.Request.Context(), db, avatars, user)
+
+		respondCreated(c, fmt.Sprintf("/api/v1/users/%d", user.ID), user)
+	})
+}
+
+// PATCH /api/v1/users/:id
+func userPatchHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var patch UserPatch
+		if err := c.BindJSON(&patch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if patch.Status != nil {
+			_, err := db.Exec("UPDATE users SET status = $1 WHERE id = $2", *patch.Status, id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// PUT /api/v1/users/:id/projects/:project_id
+func userProjectAddHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		project_id := c.Param("project_id")
+
+		_, err := db.Exec("INSERT INTO user_projects (user_id, project_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, project_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// POST /api/v1/projects
+func projectCreateHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		var project NewProject
+		if err := c.BindJSON(&project); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		created, err := insertProject(db, project)
+		if err != nil {
+			if err == errProjectExists {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/projects/%d", created.ID), created)
+	})
+}
+
+// POST /api/v1/projects/:id/tasks
+func taskCreateHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		projectID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		var task Task
+		if err := c.BindJSON(&task); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		task.Project_id = projectID
+
+		created, err := insertTask(db, task)
+		if err != nil {
+			respondTaskPatchError(c, err)
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/tasks/%d", created.ID), created)
+	})
+}
+
+// GET /api/v1/projects/:id/columns
+func columnsHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var exists bool
+		if err := db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)", id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+
+		columns, err := listColumns(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"columns": columns})
+	})
+}
+
+// POST /api/v1/projects/:id/columns
+func columnCreateHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var column Column
+		if err := c.BindJSON(&column); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			var err error
+			column, err = appendColumn(tx, id, column)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/projects/%s/columns/%d", id, column.ID), column)
+	})
+}
+
+// Колонка из :columnId. Старые клиенты передают вместо id имя колонки: оно
+// принимается, но ответ помечается заголовком Deprecation. Имя из одних цифр
+// считается id.
+func columnParam(c *gin.Context) columnRef {
+	param := c.Param("columnId")
+	if id, err := strconv.Atoi(param); err == nil && id > 0 {
+		return columnRef{id: id}
+	}
+	c.Header("Deprecation", "true")
+	return columnByName(param)
+}
+
+// PATCH /api/v1/projects/:id/columns/:columnId
+// Меняет имя, цвет или категорию колонки
+func columnUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		ref := columnParam(c)
+
+		var column Column
+		if err := c.BindJSON(&column); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			var err error
+			column, err = updateColumn(tx, id, ref, column)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, column)
+	})
+}
+
+// POST /api/v1/projects/:id/columns/:columnId/move
+func columnMoveHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		ref := columnParam(c)
+
+		var move ColumnMove
+		if err := c.BindJSON(&move); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var columns []Column
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			if err := moveColumn(tx, id, ref, move.Position); err != nil {
+				return err
+			}
+			var err error
+			columns, err = listColumns(tx, id)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"columns": columns})
+	})
+}
+
+// PUT /api/v1/projects/:id/columns/order
+func columnOrderHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var order ColumnOrder
+		if err := c.BindJSON(&order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var columns []Column
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			if err := reorderColumns(tx, id, order.Columns); err != nil {
+				return err
+			}
+			var err error
+			columns, err = listColumns(tx, id)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"columns": columns})
+	})
+}
+
+// GET /api/v1/projects/:id/members?status=online
+func projectMembersHandler(db *sqlx.DB) gin.HandlerFunc {
+	all := projectUsersHandler(db)
+	online := projectUsersOnlineHandler(db)
+	return gin.HandlerFunc(func(c *gin.Context) {
+		switch c.Query("status") {
+		case "":
+			all(c)
+		case "online":
+			online(c)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status filter"})
+		}
+	})
+}
+
+// POST /api/v1/projects/:id/members
+func projectMemberAddHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		projectID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+
+		var user_login User
+		if err := c.BindJSON(&user_login); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var member User
+		err = db.Get(&member, "SELECT id, name, role, status, COALESCE(avatar_key, '') AS avatar FROM users WHERE login = $1", user_login.Login)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err = db.Exec("INSERT INTO user_projects (user_id, project_id) VALUES ($1, $2)", member.ID, projectID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "User already in project"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		member.Avatar = avatarURL(member.ID, member.Avatar)
+		respondCreated(c, fmt.Sprintf("/api/v1/projects/%d/members/%d", projectID, member.ID), member)
+	})
+}
+
+// DELETE /api/v1/projects/:id/members/:userId
+func projectMemberDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		userID := c.Param("userId")
+
+		res, err := db.Exec("DELETE FROM user_projects WHERE user_id = $1 AND project_id = $2", userID, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of the project"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// POST /api/v1/projects/:id/grants
+func grantCreateHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var grant Grant
+		if err := c.BindJSON(&grant); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		grant, err := insertGrant(db, id, grant)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/projects/%s/grants/%s", id, grant.ID), grant)
+	})
+}
+
+// POST /api/v1/tasks/:id/files
+func taskFileCreateHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := addTaskFile(c, db, files, uploads)
+		if !ok {
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/tasks/%d/files/%d", file.TaskID, file.ID), file)
+	})
+}
+
+// PATCH /api/v1/projects/:id/grants/:grantId
+func grantPatchHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		grantID := c.Param("grantId")
+
+		var grant Grant
+		if err := c.BindJSON(&grant); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ok := updateVersioned(c, db, grantRow(id, grantID), func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("UPDATE grants SET descr = $1, num = $2, name = $3 WHERE id = $4 AND project_id = $5", grant.Descr, grant.Num, grant.Name, grantID, id)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		updated, err := getGrant(db, id, grantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	})
+}
+
+// DELETE /api/v1/projects/:id/grants/:grantId
+func grantDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		grantID := c.Param("grantId")
+
+		res, err := db.Exec("DELETE FROM grants WHERE id = $1 AND project_id = $2", grantID, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Grant not found"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetMissingResource(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for path, message := range map[string]string{
+		"/api/v1/tasks/999": "Task not found",
+		"/api/v1/users/999": "User not found",
+	} {
+		var body struct {
+			Error string `json:"error"`
+		}
+		resp := doJSON(t, srv, http.MethodGet, path, nil, nil, &body)
+		if resp.StatusCode != http.StatusNotFound || body.Error != message {
+			t.Errorf("GET %s: status %d, error %q, want 404 %q", path, resp.StatusCode, body.Error, message)
+		}
+	}
+}
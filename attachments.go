@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Сколько живёт ссылка на скачивание из хранилища
+const downloadURLTTL = 5 * time.Minute
+
+// Пользователь, от имени которого выполняется запрос.
+// Сессий пока нет, клиент передаёт свой id в заголовке X-User-ID. Только в
+// заголовке: параметр в ссылке попал бы в логи и переадресации. Когда появится
+// авторизация, пользователь будет браться из неё.
+func requestUserID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// Вложение задачи вместе с проверкой, что пользователь состоит в её проекте.
+// При ошибке сам отвечает клиенту и возвращает false.
+func memberTaskFile(c *gin.Context, db *sqlx.DB) (File, bool) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID header is required"})
+		return File{}, false
+	}
+
+	var file File
+	var projectID int
+	var hasThumbnails bool
+	err := db.QueryRow("SELECT files.id, files.task_id, files.name, files.object_name, files.content_type, files.size, files.scan_status, files.has_thumbnails, files.version, tasks.project_id FROM files JOIN tasks ON tasks.id = files.task_id WHERE files.id = $1 AND files.task_id = $2", c.Param("fileId"), c.Param("id")).
+		Scan(&file.ID, &file.TaskID, &file.Name, &file.FileUuid, &file.ContentType, &file.Size, &file.ScanStatus, &hasThumbnails, &file.Version, &projectID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return File{}, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return File{}, false
+	}
+
+	var count int
+	err = db.Get(&count, "SELECT COUNT(*) FROM user_projects WHERE user_id = $1 AND project_id = $2", userID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return File{}, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is not a member of the project"})
+		return File{}, false
+	}
+
+	file.Thumbnails = thumbnailURLs(file, hasThumbnails)
+	return file, true
+}
+
+func attachmentDisposition(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+// GET /api/v1/tasks/:id/files/:fileId
+// По умолчанию отдаёт файл через сервер с поддержкой Range.
+// С ?redirect=true перенаправляет на временную ссылку хранилища, если оно их умеет.
+func taskFileDownloadHandler(db *sqlx.DB, files ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+		if file.ScanStatus != scanClean {
+			c.JSON(http.StatusConflict, gin.H{"error": "File is waiting for a virus scan", "scan_status": file.ScanStatus})
+			return
+		}
+		disposition := attachmentDisposition(file.Name)
+
+		if redirect, _ := strconv.ParseBool(c.Query("redirect")); redirect {
+			url, err := files.PresignGet(c.Request.Context(), file.FileUuid, disposition, downloadURLTTL)
+			if err == nil {
+				c.Header("Cache-Control", "no-store")
+				c.Redirect(http.StatusFound, url)
+				return
+			}
+			if err != errPresignNotSupported {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				fmt.Println("error: ", err.Error())
+				return
+			}
+		}
+
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = contentTypeByKey(file.Name)
+		}
+		serveObject(c, files, file.FileUuid, contentType, disposition)
+	})
+}
+
+// GET /api/v1/tasks/:id/files/:fileId/thumbnails/:size
+// Миниатюра не меняется, пока существует файл, поэтому её можно кэшировать.
+func taskFileThumbnailHandler(db *sqlx.DB, files ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+		if file.ScanStatus != scanClean {
+			c.JSON(http.StatusConflict, gin.H{"error": "File is waiting for a virus scan", "scan_status": file.ScanStatus})
+			return
+		}
+		size, err := strconv.Atoi(c.Param("size"))
+		if _, ok := file.Thumbnails[c.Param("size")]; err != nil || !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnail not found"})
+			return
+		}
+
+		c.Header("Cache-Control", "private, max-age=86400")
+		serveObject(c, files, thumbnailKey(file.FileUuid, size), "image/jpeg", "inline")
+	})
+}
+
+// Отдаёт объект через http.ServeContent: Range, If-Modified-Since, HEAD.
+// contentType используется, если хранилище не знает тип объекта.
+func serveObject(c *gin.Context, files ObjectStore, key string, contentType string, disposition string) {
+	info, err := files.Stat(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File content is missing from storage"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return
+	}
+
+	content := &objectReader{ctx: c.Request.Context(), store: files, key: key, size: info.Size}
+	defer content.Close()
+
+	if info.ContentType != "" {
+		contentType = info.ContentType
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", disposition)
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime, content)
+}
+
+// io.ReadSeeker поверх ObjectStore для http.ServeContent.
+// Seek только запоминает позицию, следующий Read открывает объект с этого места,
+// поэтому для Range запросов из хранилища читается только нужная часть.
+type objectReader struct {
+	ctx    context.Context
+	store  ObjectStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.store.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// DELETE /api/v1/tasks/:id/files/:fileId
+// Запись удаляется сразу, объект из хранилища — фоновым обработчиком очереди.
+func taskFileDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+
+		n, err := deleteFiles(tx, "SELECT id FROM files WHERE id = $1", file.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		// Файл уже удалил параллельный запрос
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Пользователь берётся только из заголовка X-User-ID, параметр user_id в
+// ссылке не принимается
+func TestFileDownloadRequiresUserHeader(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/api/v1/tasks/%d/files/%d", task.ID, file.ID)
+
+	resp := doJSON(t, srv, http.MethodGet, path+"?user_id="+strconv.Itoa(user.ID), nil, nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("download with user_id parameter: status %d, want 401", resp.StatusCode)
+	}
+
+	resp = doJSON(t, srv, http.MethodGet, path, nil, map[string]string{"X-User-ID": strconv.Itoa(user.ID)}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("download with X-User-ID: status %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Аватары пользователей. Загруженная картинка (PNG, JPEG или GIF) обрезается
+// по центру до квадрата и пересчитывается в PNG размеров avatarSizes. Всё
+// это лежит в бакете аватаров под ключами users/<id>/<версия>/<размер>.png,
+// а users.avatar_key хранит users/<id>/<версия>. Каждая загрузка получает
+// новую версию, объекты прежней уходят в очередь на удаление. Пользователь
+// без загруженного аватара получает identicon, нарисованный по логину.
+// Старая колонка users.avatar с картинкой в базе при загрузке очищается.
+
+var avatarSizes = []int{32, 64, 128, 256}
+
+const avatarDefaultSize = 128
+
+// Наибольший размер загружаемой картинки
+const avatarMaxSize = 10 << 20
+
+// Не загружено картинки или не удалось её разобрать
+var errInvalidAvatar = errors.New("Avatar must be a PNG, JPEG or GIF image")
+
+func avatarObjectKey(avatarKey string, size int) string {
+	return fmt.Sprintf("%s/%d.png", avatarKey, size)
+}
+
+func avatarKeys(avatarKey string) []string {
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		keys = append(keys, avatarObjectKey(avatarKey, size))
+	}
+	return keys
+}
+
+// Разбирает загруженную картинку, размеры проверяются до того, как она разжата
+func decodeAvatar(data []byte) (image.Image, error) {
+	if !thumbnailSourceType(mimetype.Detect(data)) {
+		return nil, errInvalidAvatar
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidAvatar
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return nil, fmt.Errorf("Image is too large: %dx%d", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidAvatar
+	}
+	return src, nil
+}
+
+// Центральный квадрат картинки
+func cropSquare(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), src, image.Pt(x, y), draw.Src)
+	return square
+}
+
+// Квадрат стороной size: большие картинки уменьшаются усреднением, маленькие
+// увеличиваются повторением пикселей
+func scaleAvatar(square *image.RGBA, size int) image.Image {
+	if square.Bounds().Dx() >= size {
+		return resizeImage(square, size)
+	}
+	src := resizeImage(square, square.Bounds().Dx())
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dst.Set(x, y, src.At(x*side/size, y*side/size))
+		}
+	}
+	return dst
+}
+
+// Записывает картинку src как новую версию аватара пользователя и ставит
+// прежнюю в очередь на удаление. Для неизвестного пользователя возвращает
+// sql.ErrNoRows, записанное тогда тоже удаляется.
+func storeAvatar(ctx context.Context, db *sqlx.DB, avatars ObjectStore, userID int, src image.Image) (string, error) {
+	avatarKey, err := putAvatar(ctx, db, avatars, userID, src)
+	if err != nil {
+		return "", err
+	}
+	if err := setAvatarKey(db, userID, avatarKey); err != nil {
+		discardAvatar(db, avatarKey)
+		return "", err
+	}
+	return avatarKey, nil
+}
+
+// Записывает размеры аватара в хранилище под новой версией
+func putAvatar(ctx context.Context, db *sqlx.DB, avatars ObjectStore, userID int, src image.Image) (string, error) {
+	avatarKey := fmt.Sprintf("users/%d/%s", userID, uuid.New().String())
+	square := cropSquare(src)
+	for _, size := range avatarSizes {
+		var out bytes.Buffer
+		if err := png.Encode(&out, scaleAvatar(square, size)); err != nil {
+			return "", err
+		}
+		if err := avatars.Put(ctx, avatarObjectKey(avatarKey, size), &out, "image/png"); err != nil {
+			discardAvatar(db, avatarKey)
+			return "", err
+		}
+	}
+	return avatarKey, nil
+}
+
+func setAvatarKey(db *sqlx.DB, userID int, avatarKey string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	if err := tx.Get(&previous, "SELECT avatar_key FROM users WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET avatar_key = $1, avatar = NULL WHERE id = $2", avatarKey, userID); err != nil {
+		return err
+	}
+	if previous.Valid && previous.String != "" {
+		for _, key := range avatarKeys(previous.String) {
+			if err := enqueueObjectDeletion(tx, avatarsStoreName, key); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Ставит в очередь на удаление версию аватара, которая так и не попала в users
+func discardAvatar(db *sqlx.DB, avatarKey string) {
+	tx, err := db.Beginx()
+	if err == nil {
+		defer tx.Rollback()
+		for _, key := range avatarKeys(avatarKey) {
+			if err = enqueueObjectDeletion(tx, avatarsStoreName, key); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		fmt.Println("error: avatar: ", avatarKey, err.Error())
+	}
+}
+
+// Identicon: симметричный узор 5x5 и цвет, выведенные из SHA-256 логина
+func identicon(login string) image.Image {
+	const cells, cell, margin = 5, 48, 8
+	sum := sha256.Sum256([]byte(login))
+	fg := color.RGBA{R: 48 + sum[0]%160, G: 48 + sum[1]%160, B: 48 + sum[2]%160, A: 255}
+
+	side := cells*cell + 2*margin
+	img := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 240, G: 240, B: 240, A: 255}}, image.Point{}, draw.Src)
+	for row := 0; row < cells; row++ {
+		for col := 0; col < (cells+1)/2; col++ {
+			if sum[3+row*3+col]&1 == 0 {
+				continue
+			}
+			for _, x := range []int{col, cells - 1 - col} {
+				rect := image.Rect(margin+x*cell, margin+row*cell, margin+(x+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, rect, &image.Uniform{C: fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// Ставит пользователю identicon вместо аватара
+func storeIdenticon(ctx context.Context, db *sqlx.DB, avatars ObjectStore, user User) (string, error) {
+	return storeAvatar(ctx, db, avatars, user.ID, identicon(user.Login))
+}
+
+// Аватар по умолчанию для только что созданного пользователя. Без него
+// пользователь всё равно создан, поэтому ошибка только пишется в лог.
+func createDefaultAvatar(ctx context.Context, db *sqlx.DB, avatars ObjectStore, user User) {
+	if avatars == nil {
+		return
+	}
+	if _, err := storeIdenticon(ctx, db, avatars, user); err != nil {
+		fmt.Println("error: avatar: ", err.Error())
+	}
+}
+
+// Версия аватара пользователя. У пользователя без версии она создаётся:
+// из картинки, сохранённой раньше в users.avatar, а если её нет или она
+// не разбирается, из identicon.
+func ensureAvatar(ctx context.Context, db *sqlx.DB, avatars ObjectStore, userID int) (string, error) {
+	var login string
+	var avatarKey sql.NullString
+	var legacy []byte
+	err := db.QueryRow("SELECT login, avatar_key, avatar FROM users WHERE id = $1", userID).Scan(&login, &avatarKey, &legacy)
+	if err != nil {
+		return "", err
+	}
+	if avatarKey.String != "" {
+		return avatarKey.String, nil
+	}
+
+	src := identicon(login)
+	if len(legacy) > 0 {
+		if decoded, err := decodeAvatar(legacy); err == nil {
+			src = decoded
+		}
+	}
+	created, err := putAvatar(ctx, db, avatars, userID, src)
+	if err != nil {
+		return "", err
+	}
+
+	// Одновременный запрос мог успеть раньше, тогда берём его версию
+	res, err := db.Exec("UPDATE users SET avatar_key = $1, avatar = NULL WHERE id = $2 AND avatar_key IS NULL", created, userID)
+	if err != nil {
+		discardAvatar(db, created)
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		discardAvatar(db, created)
+		err := db.Get(&avatarKey, "SELECT avatar_key FROM users WHERE id = $1", userID)
+		return avatarKey.String, err
+	}
+	return created, nil
+}
+
+// Ссылка на аватар для ответов API. С версией ссылка меняется при каждой
+// загрузке, и её можно кэшировать без срока.
+func avatarURL(userID int, avatarKey string) string {
+	url := fmt.Sprintf("/avatars/%d", userID)
+	if avatarKey != "" {
+		url += "?v=" + path.Base(avatarKey)
+	}
+	return url
+}
+
+// Заменяет в Avatar версию, выбранную из users.avatar_key, на ссылку
+func setAvatarURLs(users []User) {
+	for i := range users {
+		users[i].Avatar = avatarURL(users[i].ID, users[i].Avatar)
+	}
+}
+
+// Ссылка на аватар исполнителя задачи, пусто, если исполнителя нет
+func assigneeAvatarURL(task Task) string {
+	userID, err := strconv.Atoi(nullStringToString(task.Empl_id))
+	if err != nil {
+		return ""
+	}
+	return avatarURL(userID, task.Avatar)
+}
+
+// GET /avatars/:userId?size=64&v=<версия>
+// size — один из avatarSizes, по умолчанию avatarDefaultSize. Ссылка с
+// текущей версией кэшируется навсегда, без версии — ненадолго.
+func avatarHandler(db *sqlx.DB, avatars ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		size := avatarDefaultSize
+		if value := c.Query("size"); value != "" {
+			size, err = strconv.Atoi(value)
+			if err != nil || !slices.Contains(avatarSizes, size) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Size must be one of %v", avatarSizes)})
+				return
+			}
+		}
+
+		avatarKey, err := ensureAvatar(c.Request.Context(), db, avatars, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		version := path.Base(avatarKey)
+		if c.Query("v") == version {
+			c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			c.Header("Cache-Control", "public, max-age=300")
+		}
+		// If-None-Match проверяет http.ServeContent
+		c.Header("ETag", fmt.Sprintf(`"%s-%d"`, version, size))
+		serveObject(c, avatars, avatarObjectKey(avatarKey, size), "image/png", "inline")
+	})
+}
+
+// PUT /api/v1/users/:id/avatar
+func profileUpdateAvatarHandler(db *sqlx.DB, avatars ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatarMaxSize+multipartOverhead)
+		file, _, err := c.Request.FormFile("image")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondFileTooLarge(c, avatarMaxSize)
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, avatarMaxSize+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if len(data) > avatarMaxSize {
+			respondFileTooLarge(c, avatarMaxSize)
+			return
+		}
+		src, err := decodeAvatar(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		avatarKey, err := storeAvatar(c.Request.Context(), db, avatars, id, src)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Avatar updated", "avatar": avatarURL(id, avatarKey)})
+	})
+}
+
+// DELETE /api/v1/users/:id/avatar
+// Заменяет загруженный аватар на identicon
+func avatarResetHandler(db *sqlx.DB, avatars ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		user := User{ID: id}
+		if err := db.Get(&user.Login, "SELECT login FROM users WHERE id = $1", id); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		avatarKey, err := storeIdenticon(c.Request.Context(), db, avatars, user)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Avatar reset", "avatar": avatarURL(id, avatarKey)})
+	})
+}
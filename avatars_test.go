@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestCropAndScaleAvatar(t *testing.T) {
+	// Красная полоса в центре широкой картинки попадает в квадрат
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		src.Set(150, y, color.RGBA{R: 255, A: 255})
+	}
+	square := cropSquare(src)
+	if square.Rect != image.Rect(0, 0, 100, 100) {
+		t.Fatalf("cropped to %v, want 100x100", square.Rect)
+	}
+	if square.RGBAAt(50, 50) != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("center pixel = %v, want red", square.RGBAAt(50, 50))
+	}
+	if tall := cropSquare(image.NewRGBA(image.Rect(10, 20, 50, 200))); tall.Rect != image.Rect(0, 0, 40, 40) {
+		t.Errorf("tall image cropped to %v, want 40x40", tall.Rect)
+	}
+
+	// Меньшие размеры уменьшаются, большие увеличиваются
+	for _, size := range avatarSizes {
+		if got := scaleAvatar(square, size).Bounds(); got != image.Rect(0, 0, size, size) {
+			t.Errorf("scaleAvatar(100x100, %d) = %v", size, got)
+		}
+	}
+}
+
+func TestEnsureAvatarMigratesLegacy(t *testing.T) {
+	db := newTestDB(t)
+	avatars := newMemoryStore()
+	ctx := context.Background()
+
+	user, err := insertUser(db, User{Name: "Ann", Login: "ann", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var legacy bytes.Buffer
+	red := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(red.Pix); i += 4 {
+		copy(red.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	if err := png.Encode(&legacy, red); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec("UPDATE users SET avatar = $1 WHERE id = $2", legacy.Bytes(), user.ID)
+
+	avatarKey, err := ensureAvatar(ctx, db, avatars, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored struct {
+		AvatarKey *string `db:"avatar_key"`
+		Avatar    []byte  `db:"avatar"`
+	}
+	if err := db.Get(&stored, "SELECT avatar_key, avatar FROM users WHERE id = $1", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if stored.AvatarKey == nil || *stored.AvatarKey != avatarKey || stored.Avatar != nil {
+		t.Fatalf("after migration avatar_key = %v, avatar = %d bytes", stored.AvatarKey, len(stored.Avatar))
+	}
+
+	for _, size := range avatarSizes {
+		body, _, err := avatars.Get(ctx, avatarObjectKey(avatarKey, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != size {
+			t.Errorf("avatar %d is %v", size, img.Bounds())
+		}
+		if r, g, b, _ := img.At(size/2, size/2).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+			t.Errorf("avatar %d is not the legacy image", size)
+		}
+	}
+
+	// Второй раз версия уже есть
+	again, err := ensureAvatar(ctx, db, avatars, user.ID)
+	if err != nil || again != avatarKey {
+		t.Errorf("second ensureAvatar = %q, %v, want %q", again, err, avatarKey)
+	}
+	if objects, _ := avatars.List(ctx, ""); len(objects) != len(avatarSizes) {
+		t.Errorf("%d objects after the second call, want %d", len(objects), len(avatarSizes))
+	}
+}
+
+func TestSetAvatarKeyQueuesPrevious(t *testing.T) {
+	db := newTestDB(t)
+
+	user, err := insertUser(db, User{Name: "Ann", Login: "ann", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, avatarKey := range []string{"users/1/first", "users/1/second"} {
+		if err := setAvatarKey(db, user.ID, avatarKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var queued []string
+	if err := db.Select(&queued, "SELECT object_key FROM object_deletions WHERE store = $1 ORDER BY id", avatarsStoreName); err != nil {
+		t.Fatal(err)
+	}
+	if want := avatarKeys("users/1/first"); strings.Join(queued, ",") != strings.Join(want, ",") {
+		t.Errorf("queued %v, want %v", queued, want)
+	}
+
+	var current string
+	if err := db.Get(&current, "SELECT avatar_key FROM users WHERE id = $1", user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if current != "users/1/second" {
+		t.Errorf("avatar_key = %q", current)
+	}
+}
+
+func TestAvatarCaching(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	user, err := c.CreateUser(ctx, api.User{Name: "Ann", Login: "ann", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = c.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := user.Avatar
+	if !strings.Contains(current, "?v=") {
+		t.Fatalf("avatar URL without a version: %q", current)
+	}
+	immutable := "public, max-age=31536000, immutable"
+
+	resp := doJSON(t, srv, http.MethodGet, current, nil, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != immutable {
+		t.Fatalf("current version: status %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	etag := resp.Header.Get("ETag")
+	resp = doJSON(t, srv, http.MethodGet, current, nil, map[string]string{"If-None-Match": etag}, nil)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("matching If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+	resp = doJSON(t, srv, http.MethodGet, current+"&size=32", nil, map[string]string{"If-None-Match": etag}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("ETag of another size: status %d, want 200", resp.StatusCode)
+	}
+
+	resp = doJSON(t, srv, http.MethodGet, fmt.Sprintf("/avatars/%d", user.ID), nil, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") == immutable {
+		t.Errorf("without a version: status %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+
+	// После новой загрузки старая ссылка больше не кэшируется навсегда
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 40, 40))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateAvatar(ctx, user.ID, "avatar.png", &picture); err != nil {
+		t.Fatal(err)
+	}
+	resp = doJSON(t, srv, http.MethodGet, current, nil, map[string]string{"If-None-Match": etag}, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") == immutable {
+		t.Errorf("stale version: status %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	user, err = c.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Avatar == current {
+		t.Fatal("avatar URL did not change after upload")
+	}
+	resp = doJSON(t, srv, http.MethodGet, user.Avatar, nil, nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != immutable {
+		t.Errorf("new version: status %d, Cache-Control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+}
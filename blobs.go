@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/jmoiron/sqlx"
+)
+
+// Дедупликация вложений. Содержимое хранится один раз под адресом
+// sha256/<хеш><расширение>, сколько бы раз его ни прикрепили. В blobs для
+// каждого такого объекта ведётся число ссылок из files: insertTaskFile его
+// увеличивает, deleteFiles уменьшает, и с последней ссылкой объект вместе
+// с миниатюрами уходит в очередь на удаление. Файлы, загруженные раньше,
+// лежат под uuid, в blobs не попадают и удаляются как прежде.
+
+const blobPrefix = "sha256/"
+
+// Найденное содержимое удалили раньше, чем на него успели сослаться
+var errBlobGone = errors.New("blob was deleted")
+
+// Объект, на который ссылается запись в files
+type fileBlob struct {
+	// Пусто у файлов, загруженных до дедупликации
+	sha256        string
+	objectName    string
+	hasThumbnails bool
+	// Объект уже был в хранилище до этой загрузки
+	existing bool
+}
+
+func blobKey(sum string, extension string) string {
+	return blobPrefix + sum + extension
+}
+
+// Объект и его миниатюры
+func (b fileBlob) keys() []string {
+	keys := []string{b.objectName}
+	if b.hasThumbnails {
+		for _, size := range thumbnailSizes {
+			keys = append(keys, thumbnailKey(b.objectName, size))
+		}
+	}
+	return keys
+}
+
+// SHA-256 содержимого в hex. Читает r с начала и возвращает в начало.
+func contentSHA256(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func objectSHA256(ctx context.Context, files ObjectStore, key string) (string, error) {
+	body, _, err := files.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Сохранённое содержимое с хешем sum, existing = false, если его ещё нет
+func findBlob(q sqlx.Queryer, sum string) (fileBlob, error) {
+	blob := fileBlob{sha256: sum}
+	err := q.QueryRowx("SELECT object_name, has_thumbnails FROM blobs WHERE sha256 = $1", sum).Scan(&blob.objectName, &blob.hasThumbnails)
+	if err == sql.ErrNoRows {
+		return blob, nil
+	}
+	blob.existing = err == nil
+	return blob, err
+}
+
+// Записывает содержимое под ключом key и строит миниатюры, если это картинка.
+// Возвращает, построены ли миниатюры.
+func storeBlob(ctx context.Context, files ObjectStore, key string, content io.ReadSeeker, detected *mimetype.MIME) (bool, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if !thumbnailSourceType(detected) {
+		return false, files.Put(ctx, key, content, detected.String())
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return false, err
+	}
+	if err := files.Put(ctx, key, bytes.NewReader(data), detected.String()); err != nil {
+		return false, err
+	}
+	if err := storeThumbnails(ctx, files, key, data); err != nil {
+		fmt.Println("error: thumbnails: ", key, err.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+// Копирует загруженный объект под адрес содержимого key
+func copyBlob(ctx context.Context, files ObjectStore, uploaded string, key string, detected *mimetype.MIME) (bool, error) {
+	if err := files.Copy(ctx, uploaded, key); err != nil {
+		return false, err
+	}
+	if detected == nil || !thumbnailSourceType(detected) {
+		return false, nil
+	}
+	if err := storeObjectThumbnails(ctx, files, key); err != nil {
+		fmt.Println("error: thumbnails: ", key, err.Error())
+		return false, nil
+	}
+	return true, nil
+}
+
+// Прикрепляет к задаче содержимое с хешем sum. Если такого содержимого ещё
+// нет, store записывает его под ключом key и сообщает, построены ли миниатюры.
+func insertBlobFile(db *sqlx.DB, uploads uploadPolicy, file File, uploadedBy sql.NullInt64, replaces int, sum string, key string, store func(key string) (bool, error)) (File, error) {
+	for {
+		blob, err := findBlob(db, sum)
+		if err != nil {
+			return File{}, err
+		}
+		if !blob.existing {
+			blob.objectName = key
+			// Дожидаемся задания очистки, которое уже удаляет этот ключ,
+			// чтобы оно не удалило объект сразу после записи
+			if err := unqueueBlobDeletion(db, key); err != nil {
+				return File{}, err
+			}
+			if blob.hasThumbnails, err = store(key); err != nil {
+				return File{}, err
+			}
+		}
+
+		file.FileUuid = blob.objectName
+		created, err := insertTaskFile(db, uploads, file, uploadedBy, blob, replaces)
+		if err == errBlobGone {
+			// Содержимое записываем заново, теперь уже под своей ссылкой
+			continue
+		}
+		if err != nil {
+			if discardErr := discardBlob(db, blob); discardErr != nil {
+				fmt.Println("error: ", discardErr.Error())
+			}
+			return File{}, err
+		}
+		return created, nil
+	}
+}
+
+// Добавляет ссылку на содержимое для новой записи в files
+func acquireBlob(tx *sqlx.Tx, blob fileBlob, size int64, contentType string) error {
+	if blob.sha256 == "" {
+		return nil
+	}
+	if blob.existing {
+		res, err := tx.Exec("UPDATE blobs SET refs = refs + 1 WHERE sha256 = $1", blob.sha256)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errBlobGone
+		}
+		return nil
+	}
+
+	// Такое же содержимое могло недавно потерять последнюю ссылку и ждать
+	// удаления, а объект под тем же ключом только что записан заново
+	if err := unqueueBlobDeletion(tx, blob.objectName); err != nil {
+		return err
+	}
+	// Одновременная загрузка того же содержимого могла создать запись первой
+	_, err := tx.Exec("INSERT INTO blobs (sha256, object_name, size, content_type, has_thumbnails, refs) VALUES ($1, $2, $3, $4, $5, 1) ON CONFLICT (sha256) DO UPDATE SET refs = blobs.refs + 1, has_thumbnails = blobs.has_thumbnails OR excluded.has_thumbnails",
+		blob.sha256, blob.objectName, size, contentType, blob.hasThumbnails)
+	return err
+}
+
+// Снимает с очереди удаление объекта key и его миниатюр. Задание, которое
+// уже выполняется, держит блокировку своей строки, и DELETE ждёт его конца.
+func unqueueBlobDeletion(db sqlx.Execer, key string) error {
+	keys := []interface{}{filesStoreName, key}
+	placeholders := []string{"$2"}
+	for _, size := range thumbnailSizes {
+		keys = append(keys, thumbnailKey(key, size))
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(keys)))
+	}
+	_, err := db.Exec("DELETE FROM object_deletions WHERE store = $1 AND object_key IN ("+strings.Join(placeholders, ", ")+")", keys...)
+	return err
+}
+
+// Ссылаются ли blobs или files на объект хранилища файлов, сам по себе или
+// как на миниатюру
+func fileObjectReferenced(q sqlx.Queryer, key string) (bool, error) {
+	conditions := []string{
+		"EXISTS (SELECT 1 FROM blobs WHERE object_name = $1)",
+		"EXISTS (SELECT 1 FROM files WHERE object_name = $1)",
+	}
+	for _, size := range thumbnailSizes {
+		conditions = append(conditions,
+			"EXISTS (SELECT 1 FROM blobs WHERE has_thumbnails AND "+thumbnailKeyColumn(size)+" = $1)",
+			"EXISTS (SELECT 1 FROM files WHERE has_thumbnails AND "+thumbnailKeyColumn(size)+" = $1)")
+	}
+	var referenced bool
+	err := sqlx.Get(q, &referenced, "SELECT "+strings.Join(conditions, " OR "), key)
+	return referenced, err
+}
+
+// Ставит в очередь на удаление содержимое, записанное для файла, который
+// так и не появился в files. Если на него уже ссылаются, оно остаётся.
+func discardBlob(db *sqlx.DB, blob fileBlob) error {
+	if blob.existing || blob.sha256 == "" {
+		return nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var referenced bool
+	if err := tx.Get(&referenced, "SELECT EXISTS (SELECT 1 FROM blobs WHERE sha256 = $1)", blob.sha256); err != nil {
+		return err
+	}
+	if referenced {
+		return nil
+	}
+	for _, key := range blob.keys() {
+		if err := enqueueObjectDeletion(tx, filesStoreName, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Снимает ссылки файлов из fileQuery, до их удаления. Содержимое без ссылок
+// ставится в очередь на удаление вместе с миниатюрами.
+func releaseBlobs(tx *sqlx.Tx, fileQuery string, args ...interface{}) error {
+	referenced := "SELECT sha256 FROM files WHERE sha256 IS NOT NULL AND id IN (" + fileQuery + ")"
+	_, err := tx.Exec("UPDATE blobs SET refs = refs - (SELECT COUNT(*) FROM files WHERE files.sha256 = blobs.sha256 AND files.id IN ("+fileQuery+")) WHERE sha256 IN ("+referenced+")", args...)
+	if err != nil {
+		return err
+	}
+
+	released := "refs <= 0 AND sha256 IN (" + referenced + ")"
+	_, err = tx.Exec("INSERT INTO object_deletions (store, object_key) SELECT '"+filesStoreName+"', object_name FROM blobs WHERE "+released, args...)
+	if err != nil {
+		return err
+	}
+	for _, size := range thumbnailSizes {
+		_, err := tx.Exec("INSERT INTO object_deletions (store, object_key) SELECT '"+filesStoreName+"', "+thumbnailKeyColumn(size)+" FROM blobs WHERE has_thumbnails AND "+released, args...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM blobs WHERE "+released, args...)
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Задание очистки, взятое до того, как на содержимое снова сослались, не
+// удаляет объект
+func TestCleanupSkipsReferencedBlob(t *testing.T) {
+	db := newTestDB(t)
+	files := newMemoryStore()
+	ctx := context.Background()
+
+	referenced := blobKey("aaaa", ".txt")
+	orphan := blobKey("bbbb", ".txt")
+	for _, key := range []string{referenced, orphan} {
+		if err := files.Put(ctx, key, strings.NewReader("content"), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		db.MustExec("INSERT INTO object_deletions (store, object_key) VALUES ($1, $2)", filesStoreName, key)
+	}
+	db.MustExec("INSERT INTO blobs (sha256, object_name, size, content_type, has_thumbnails, refs) VALUES ('aaaa', $1, 7, 'text/plain', false, 1)", referenced)
+
+	deleted, failed, err := cleanupObjects(ctx, db, objectStores{files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || failed != 0 {
+		t.Errorf("deleted %d, failed %d, want 1 and 0", deleted, failed)
+	}
+
+	if _, err := files.Stat(ctx, referenced); err != nil {
+		t.Errorf("referenced object: %v", err)
+	}
+	if _, err := files.Stat(ctx, orphan); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("orphan object: %v, want errObjectNotFound", err)
+	}
+	var queued int
+	if err := db.Get(&queued, "SELECT COUNT(*) FROM object_deletions"); err != nil {
+		t.Fatal(err)
+	}
+	if queued != 0 {
+		t.Errorf("%d jobs left in the queue", queued)
+	}
+}
+
+func TestUnqueueBlobDeletion(t *testing.T) {
+	db := newTestDB(t)
+
+	key := blobKey("cccc", ".png")
+	keys := []string{key, "other"}
+	for _, size := range thumbnailSizes {
+		keys = append(keys, thumbnailKey(key, size))
+	}
+	for _, k := range keys {
+		db.MustExec("INSERT INTO object_deletions (store, object_key) VALUES ($1, $2)", filesStoreName, k)
+	}
+	db.MustExec("INSERT INTO object_deletions (store, object_key) VALUES ($1, $2)", avatarsStoreName, key)
+
+	if err := unqueueBlobDeletion(db, key); err != nil {
+		t.Fatal(err)
+	}
+
+	var left []string
+	if err := db.Select(&left, "SELECT store || ':' || object_key FROM object_deletions ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filesStoreName + ":other", avatarsStoreName + ":" + key}
+	if strings.Join(left, ",") != strings.Join(want, ",") {
+		t.Errorf("queue = %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Очередь удаления объектов из хранилища. Задание пишется в той же транзакции,
+// что и удаление записи из базы, поэтому объект не потеряется, даже если
+// хранилище недоступно или сервер перезапустится. Фоновый обработчик
+// повторяет неудачные попытки с растущей паузой.
+
+const (
+	cleanupInterval   = 15 * time.Second
+	cleanupBatch      = 100
+	cleanupLease      = 5 * time.Minute
+	cleanupMaxBackoff = time.Hour
+)
+
+// Имена хранилищ в object_deletions.store
+const (
+	filesStoreName   = "files"
+	avatarsStoreName = "avatars"
+)
+
+func (s objectStores) named(name string) (ObjectStore, bool) {
+	switch name {
+	case filesStoreName:
+		return s.files, s.files != nil
+	case avatarsStoreName:
+		return s.avatars, s.avatars != nil
+	}
+	return nil, false
+}
+
+type objectDeletion struct {
+	ID        int    `db:"id"`
+	Store     string `db:"store"`
+	ObjectKey string `db:"object_key"`
+	Attempts  int    `db:"attempts"`
+}
+
+func enqueueObjectDeletion(tx *sqlx.Tx, store string, key string) error {
+	_, err := tx.Exec("INSERT INTO object_deletions (store, object_key) VALUES ($1, $2)", store, key)
+	return err
+}
+
+// SQL-выражение с ключом миниатюры для строки с колонкой object_name
+func thumbnailKeyColumn(size int) string {
+	return fmt.Sprintf("'%s%d/' || object_name || '.jpg'", thumbnailPrefix, size)
+}
+
+// Удаляет записи о файлах задач из taskQuery и ставит их объекты в очередь.
+// taskQuery — подзапрос, возвращающий id задач.
+func deleteTaskFiles(tx *sqlx.Tx, taskQuery string, args ...interface{}) error {
+	_, err := deleteFiles(tx, "SELECT id FROM files WHERE task_id IN ("+taskQuery+")", args...)
+	return err
+}
+
+// Удаляет записи о файлах из fileQuery и ставит в очередь их объекты вместе
+// с миниатюрами. Объект, на который ссылаются оставшиеся записи (например,
+// восстановленная версия), не удаляется. Возвращает число удалённых записей.
+func deleteFiles(tx *sqlx.Tx, fileQuery string, args ...interface{}) (int64, error) {
+	if err := releaseBlobs(tx, fileQuery, args...); err != nil {
+		return 0, err
+	}
+
+	// Файлы до дедупликации: ссылки на их объекты ищем среди оставшихся записей
+	unreferenced := "sha256 IS NULL AND id IN (" + fileQuery + ") AND object_name NOT IN (SELECT other.object_name FROM files other WHERE other.id NOT IN (" + fileQuery + "))"
+	_, err := tx.Exec("INSERT INTO object_deletions (store, object_key) SELECT DISTINCT '"+filesStoreName+"', object_name FROM files WHERE "+unreferenced, args...)
+	if err != nil {
+		return 0, err
+	}
+	for _, size := range thumbnailSizes {
+		_, err := tx.Exec("INSERT INTO object_deletions (store, object_key) SELECT DISTINCT '"+filesStoreName+"', "+thumbnailKeyColumn(size)+" FROM files WHERE has_thumbnails AND "+unreferenced, args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := releaseStorage(tx, fileQuery, args...); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("DELETE FROM files WHERE id IN ("+fileQuery+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Удаляет задачу вместе с её файлами
+func deleteTask(db *sqlx.DB, id string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteTaskFiles(tx, "SELECT id FROM tasks WHERE id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tasks WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Пауза перед следующей попыткой: 30s, 1m, 2m ... но не больше часа
+func cleanupBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < cleanupMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > cleanupMaxBackoff {
+		backoff = cleanupMaxBackoff
+	}
+	return backoff
+}
+
+// Фоновый обработчик очереди, работает до отмены ctx
+func runCleanup(ctx context.Context, db *sqlx.DB, stores objectStores) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, _, err := cleanupObjects(ctx, db, stores); err != nil {
+			fmt.Println("error: cleanup: ", err.Error())
+		}
+		if stores.files != nil {
+			if _, err := expireResumableUploads(ctx, db, stores.files); err != nil {
+				fmt.Println("error: cleanup: resumable uploads: ", err.Error())
+			}
+		}
+		if _, err := expireDirectUploads(db); err != nil {
+			fmt.Println("error: cleanup: direct uploads: ", err.Error())
+		}
+		if _, err := expireColumnTrash(db); err != nil {
+			fmt.Println("error: cleanup: column trash: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Выполняет готовые задания очереди. Возвращает число удалённых объектов
+// и число неудачных попыток.
+func cleanupObjects(ctx context.Context, db *sqlx.DB, stores objectStores) (int, int, error) {
+	now := time.Now().UTC()
+
+	var jobs []objectDeletion
+	err := db.Select(&jobs, "SELECT id, store, object_key, attempts FROM object_deletions WHERE run_at <= $1 ORDER BY run_at LIMIT $2", now, cleanupBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleted, failed := 0, 0
+	for _, job := range jobs {
+		// Захватываем задание, чтобы его не выполнял другой экземпляр сервера
+		res, err := db.Exec("UPDATE object_deletions SET run_at = $1 WHERE id = $2 AND run_at <= $3", now.Add(cleanupLease), job.ID, now)
+		if err != nil {
+			return deleted, failed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		ok, jobFailed, err := runObjectDeletion(ctx, db, stores, job)
+		if err != nil {
+			return deleted, failed, err
+		}
+		if ok {
+			deleted++
+		}
+		if jobFailed {
+			failed++
+		}
+	}
+
+	return deleted, failed, nil
+}
+
+// Выполняет захваченное задание под блокировкой его строки. acquireBlob и
+// unqueueBlobDeletion снимают задания с содержимого, на которое снова
+// ссылаются, и ждут этой блокировки, поэтому объект не удаляется из-под новой
+// ссылки. Возвращает, удалён ли объект и не удалось ли его удалить.
+func runObjectDeletion(ctx context.Context, db *sqlx.DB, stores objectStores, job objectDeletion) (bool, bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.Get(&id, "SELECT id FROM object_deletions WHERE id = $1"+forUpdate(db), job.ID)
+	if err == sql.ErrNoRows {
+		// Задание сняли, пока мы его захватывали
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	if job.Store == filesStoreName {
+		referenced, err := fileObjectReferenced(tx, job.ObjectKey)
+		if err != nil {
+			return false, false, err
+		}
+		if referenced {
+			// Содержимое загрузили заново после того, как оно попало в очередь
+			if _, err := tx.Exec("DELETE FROM object_deletions WHERE id = $1", job.ID); err != nil {
+				return false, false, err
+			}
+			return false, false, tx.Commit()
+		}
+	}
+
+	var deleteErr error
+	if store, ok := stores.named(job.Store); ok {
+		deleteErr = store.Delete(ctx, job.ObjectKey)
+	} else {
+		deleteErr = fmt.Errorf("unknown object store %q", job.Store)
+	}
+
+	if deleteErr != nil {
+		fmt.Println("error: cleanup: ", job.Store, job.ObjectKey, deleteErr.Error())
+		_, err := tx.Exec("UPDATE object_deletions SET attempts = attempts + 1, last_error = $1, run_at = $2 WHERE id = $3",
+			deleteErr.Error(), time.Now().UTC().Add(cleanupBackoff(job.Attempts+1)), job.ID)
+		if err != nil {
+			return false, false, err
+		}
+		return false, true, tx.Commit()
+	}
+
+	if _, err := tx.Exec("DELETE FROM object_deletions WHERE id = $1", job.ID); err != nil {
+		return false, false, err
+	}
+	return true, false, tx.Commit()
+}
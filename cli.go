@@ -0,0 +1,960 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const usage = `Usage: justintime-backend [command]
+
+Commands:
+  serve                                   run the HTTP server (default)
+  migrate                                 apply pending schema migrations
+  user create -login L -name N [-password P] [-role R]
+  user disable LOGIN                      forbid the user to log in
+  user reset-password LOGIN [-password P] set a new password, random if not given
+  user storage-quota LOGIN SIZE|default   limit the space taken by the user's attachments; 0 means unlimited
+  project list
+  project delete ID
+  project transfer ID -from LOGIN -to LOGIN
+                                          hand over membership and tasks of one user to another
+  project upload-limit ID SIZE            limit attachment size in the project, e.g. 10MB; 0 removes the limit
+  project storage-quota ID SIZE|default   limit the space taken by attachments of the project; 0 means unlimited
+  files reconcile [-repair] [-dry-run]    compare the files table with the bucket; -repair fixes what is found,
+                                          -dry-run only shows what -repair would do
+  files cleanup                           delete queued objects now instead of waiting for the server
+  files scan                              scan quarantined files now with the configured SCANNER_DRIVER
+  seed                                    fill an empty database with demo data
+
+Every command except serve accepts -json to print the result as JSON.
+The database is selected with the same environment variables as the server.
+`
+
+// Команды администрирования. Без аргументов запускается HTTP сервер.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return serveCommand(nil)
+	}
+
+	switch args[0] {
+	case "serve":
+		return serveCommand(args[1:])
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "user":
+		return subcommand("user", args[1:], map[string]func([]string) error{
+			"create":         userCreateCommand,
+			"disable":        userDisableCommand,
+			"reset-password": userResetPasswordCommand,
+			"storage-quota":  userStorageQuotaCommand,
+		})
+	case "project":
+		return subcommand("project", args[1:], map[string]func([]string) error{
+			"list":          projectListCommand,
+			"delete":        projectDeleteCommand,
+			"transfer":      projectTransferCommand,
+			"upload-limit":  projectUploadLimitCommand,
+			"storage-quota": projectStorageQuotaCommand,
+		})
+	case "files":
+		return subcommand("files", args[1:], map[string]func([]string) error{
+			"reconcile": filesReconcileCommand,
+			"cleanup":   filesCleanupCommand,
+			"scan":      filesScanCommand,
+		})
+	case "seed":
+		return seedCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func subcommand(name string, args []string, commands map[string]func([]string) error) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("%s: missing subcommand", name)
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("%s: unknown subcommand %q", name, args[0])
+	}
+	return command(args[1:])
+}
+
+// Флаги команды и общий флаг -json
+type commandFlags struct {
+	*flag.FlagSet
+	json *bool
+}
+
+func newCommandFlags(name string) commandFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return commandFlags{FlagSet: fs, json: fs.Bool("json", false, "print the result as JSON")}
+}
+
+// Разбирает флаги, позиционные аргументы можно указывать до и после флагов
+func (f commandFlags) parse(args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := f.Parse(args); err != nil {
+			return nil, err
+		}
+		if f.NArg() == 0 {
+			break
+		}
+		rest = append(rest, f.Arg(0))
+		args = f.Args()[1:]
+	}
+	if len(rest) != positional {
+		return nil, fmt.Errorf("%s: expected %d argument(s), got %d", f.Name(), positional, len(rest))
+	}
+	return rest, nil
+}
+
+// Печатает результат как JSON или текстом для человека
+func (f commandFlags) print(result interface{}, text func(w io.Writer)) error {
+	if *f.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+// База с применёнными миграциями, как при запуске сервера
+func openMigratedDatabase() (*sqlx.DB, error) {
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func serveCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stores, err := newObjectStores()
+	if err != nil {
+		return err
+	}
+
+	uploads, err := newUploadPolicy()
+	if err != nil {
+		return err
+	}
+
+	scanner, err := newScanner()
+	if err != nil {
+		return err
+	}
+	if scanner != nil {
+		uploads.quarantine = true
+		go runScanner(context.Background(), db, stores.files, scanner)
+	}
+
+	go runCleanup(context.Background(), db, stores)
+
+	reconcile, err := newReconcileSchedule()
+	if err != nil {
+		return err
+	}
+	if reconcile.interval > 0 && stores.files != nil {
+		go runReconciler(context.Background(), db, stores.files, reconcile)
+	}
+
+	// gin.SetMode(gin.ReleaseMode)
+
+	r := setupRouter(db, stores, uploads)
+
+	problems, err := checkOpenAPIRoutes(openAPISpec, r.Routes())
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Println("openapi:", problem)
+	}
+
+	return r.Run()
+}
+
+func migrateCommand(args []string) error {
+	fs := newCommandFlags("migrate")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := migrate(db)
+	if err != nil {
+		return err
+	}
+	if applied == nil {
+		applied = []int{}
+	}
+
+	var version int
+	if err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"); err != nil {
+		return err
+	}
+
+	return fs.print(map[string]interface{}{"applied": applied, "version": version}, func(w io.Writer) {
+		for _, v := range applied {
+			fmt.Fprintf(w, "applied migration %d\n", v)
+		}
+		fmt.Fprintf(w, "schema version %d\n", version)
+	})
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type userCommandResult struct {
+	User User `json:"user"`
+	// Заполнен, только если пароль сгенерирован командой
+	Password string `json:"password,omitempty"`
+}
+
+func printUser(fs commandFlags, result userCommandResult, action string) error {
+	return fs.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%s user %s (id %d)\n", action, result.User.Login, result.User.ID)
+		if result.Password != "" {
+			fmt.Fprintf(w, "password: %s\n", result.Password)
+		}
+	})
+}
+
+func userCreateCommand(args []string) error {
+	fs := newCommandFlags("user create")
+	login := fs.String("login", "", "login, required")
+	name := fs.String("name", "", "display name, required")
+	password := fs.String("password", "", "password, random if empty")
+	role := fs.String("role", "", "role")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+	if *login == "" || *name == "" {
+		return errors.New("user create: -login and -name are required")
+	}
+
+	var result userCommandResult
+	if *password == "" {
+		generated, err := randomPassword()
+		if err != nil {
+			return err
+		}
+		*password = generated
+		result.Password = generated
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result.User, err = insertUser(db, User{Name: *name, Login: *login, Password: *password, Role: *role})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("login %q already exists", *login)
+		}
+		return err
+	}
+
+	return printUser(fs, result, "created")
+}
+
+func userByLogin(db *sqlx.DB, login string) (User, error) {
+	var user User
+	err := db.Get(&user, "SELECT id, name, role, login, status FROM users WHERE login = $1", login)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("user %q not found", login)
+	}
+	return user, err
+}
+
+func userDisableCommand(args []string) error {
+	fs := newCommandFlags("user disable")
+	rest, err := fs.parse(args, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user, err := userByLogin(db, rest[0])
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE users SET disabled = TRUE, status = '' WHERE id = $1", user.ID); err != nil {
+		return err
+	}
+
+	return printUser(fs, userCommandResult{User: user}, "disabled")
+}
+
+func userResetPasswordCommand(args []string) error {
+	fs := newCommandFlags("user reset-password")
+	password := fs.String("password", "", "new password, random if empty")
+	rest, err := fs.parse(args, 1)
+	if err != nil {
+		return err
+	}
+
+	var result userCommandResult
+	if *password == "" {
+		generated, err := randomPassword()
+		if err != nil {
+			return err
+		}
+		*password = generated
+		result.Password = generated
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result.User, err = userByLogin(db, rest[0])
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE users SET password = $1 WHERE id = $2", *password, result.User.ID); err != nil {
+		return err
+	}
+
+	return printUser(fs, result, "reset password of")
+}
+
+type projectSummary struct {
+	ID      int    `json:"id" db:"id"`
+	Name    string `json:"name" db:"name"`
+	Members int    `json:"members" db:"members"`
+	Tasks   int    `json:"tasks" db:"tasks"`
+}
+
+func projectListCommand(args []string) error {
+	fs := newCommandFlags("project list")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	projects := []projectSummary{}
+	err = db.Select(&projects, `SELECT projects.id, projects.name,
+		(SELECT COUNT(*) FROM user_projects WHERE user_projects.project_id = projects.id) AS members,
+		(SELECT COUNT(*) FROM tasks WHERE tasks.project_id = projects.id) AS tasks
+		FROM projects ORDER BY projects.id`)
+	if err != nil {
+		return err
+	}
+
+	return fs.print(projects, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tMEMBERS\tTASKS")
+		for _, p := range projects {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\n", p.ID, p.Name, p.Members, p.Tasks)
+		}
+	})
+}
+
+func projectIDArg(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid project ID %q", arg)
+	}
+	return id, nil
+}
+
+func projectDeleteCommand(args []string) error {
+	fs := newCommandFlags("project delete")
+	rest, err := fs.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := projectIDArg(rest[0])
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	project, err := getProject(db, strconv.Itoa(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("project %d not found", id)
+		}
+		return err
+	}
+	if err := deleteProject(db, id); err != nil {
+		return err
+	}
+
+	return fs.print(project, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted project %s (id %d)\n", project.Name, project.ID)
+	})
+}
+
+type uploadLimitResult struct {
+	Project     int   `json:"project"`
+	MaxFileSize int64 `json:"max_file_size"`
+}
+
+// Предел действует, только если он меньше общего UPLOAD_MAX_SIZE
+func projectUploadLimitCommand(args []string) error {
+	fs := newCommandFlags("project upload-limit")
+	rest, err := fs.parse(args, 2)
+	if err != nil {
+		return err
+	}
+	id, err := projectIDArg(rest[0])
+	if err != nil {
+		return err
+	}
+	size, err := parseSize(rest[1])
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("UPDATE projects SET max_file_size = $1 WHERE id = $2", size, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("project %d not found", id)
+	}
+
+	result := uploadLimitResult{Project: id, MaxFileSize: size}
+	return fs.print(result, func(w io.Writer) {
+		if size == 0 {
+			fmt.Fprintf(w, "removed upload limit of project %d\n", id)
+			return
+		}
+		fmt.Fprintf(w, "project %d accepts files up to %d bytes\n", id, size)
+	})
+}
+
+type storageQuotaResult struct {
+	Project int    `json:"project,omitempty"`
+	User    string `json:"user,omitempty"`
+	Used    int64  `json:"used"`
+	Quota   *int64 `json:"quota"`
+}
+
+// default — квота из PROJECT_STORAGE_QUOTA или USER_STORAGE_QUOTA
+func parseQuotaArg(input string) (sql.NullInt64, error) {
+	if input == "default" {
+		return sql.NullInt64{}, nil
+	}
+	size, err := parseSize(input)
+	return sql.NullInt64{Int64: size, Valid: true}, err
+}
+
+func (r storageQuotaResult) describe(w io.Writer, owner string) {
+	switch {
+	case r.Quota == nil:
+		fmt.Fprintf(w, "%s uses the default storage quota, %d bytes used\n", owner, r.Used)
+	case *r.Quota == 0:
+		fmt.Fprintf(w, "%s has unlimited storage, %d bytes used\n", owner, r.Used)
+	default:
+		fmt.Fprintf(w, "%s may store up to %d bytes, %d bytes used\n", owner, *r.Quota, r.Used)
+	}
+}
+
+func projectStorageQuotaCommand(args []string) error {
+	fs := newCommandFlags("project storage-quota")
+	rest, err := fs.parse(args, 2)
+	if err != nil {
+		return err
+	}
+	id, err := projectIDArg(rest[0])
+	if err != nil {
+		return err
+	}
+	quota, err := parseQuotaArg(rest[1])
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result := storageQuotaResult{Project: id}
+	err = db.QueryRow("UPDATE projects SET storage_quota = $1 WHERE id = $2 RETURNING storage_used", quota, id).Scan(&result.Used)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("project %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if quota.Valid {
+		result.Quota = &quota.Int64
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		result.describe(w, fmt.Sprintf("project %d", id))
+	})
+}
+
+func userStorageQuotaCommand(args []string) error {
+	fs := newCommandFlags("user storage-quota")
+	rest, err := fs.parse(args, 2)
+	if err != nil {
+		return err
+	}
+	quota, err := parseQuotaArg(rest[1])
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user, err := userByLogin(db, rest[0])
+	if err != nil {
+		return err
+	}
+	result := storageQuotaResult{User: user.Login}
+	err = db.QueryRow("UPDATE users SET storage_quota = $1 WHERE id = $2 RETURNING storage_used", quota, user.ID).Scan(&result.Used)
+	if err != nil {
+		return err
+	}
+	if quota.Valid {
+		result.Quota = &quota.Int64
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		result.describe(w, "user "+user.Login)
+	})
+}
+
+type transferResult struct {
+	Project       int    `json:"project"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	AssignedTasks int    `json:"assigned_tasks"`
+	CreatedTasks  int    `json:"created_tasks"`
+}
+
+func projectTransferCommand(args []string) error {
+	fs := newCommandFlags("project transfer")
+	from := fs.String("from", "", "login of the user who leaves the project, required")
+	to := fs.String("to", "", "login of the user who takes over, required")
+	rest, err := fs.parse(args, 1)
+	if err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("project transfer: -from and -to are required")
+	}
+	id, err := projectIDArg(rest[0])
+	if err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := getProject(db, strconv.Itoa(id)); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("project %d not found", id)
+		}
+		return err
+	}
+	fromUser, err := userByLogin(db, *from)
+	if err != nil {
+		return err
+	}
+	toUser, err := userByLogin(db, *to)
+	if err != nil {
+		return err
+	}
+
+	result, err := transferProject(db, id, fromUser, toUser)
+	if err != nil {
+		return err
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "project %d: %s replaced by %s\n", id, result.From, result.To)
+		fmt.Fprintf(w, "reassigned tasks\t%d\n", result.AssignedTasks)
+		fmt.Fprintf(w, "tasks with new creator\t%d\n", result.CreatedTasks)
+	})
+}
+
+// Передаёт участие в проекте и задачи одного пользователя другому.
+// Смена исполнителя пишется в историю задачи, как при PATCH.
+func transferProject(db *sqlx.DB, projectID int, from User, to User) (transferResult, error) {
+	result := transferResult{Project: projectID, From: from.Login, To: to.Login}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO user_projects (user_id, project_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", to.ID, projectID)
+	if err != nil {
+		return result, err
+	}
+
+	var assigned []int
+	err = tx.Select(&assigned, "SELECT id FROM tasks WHERE project_id = $1 AND empl_id = $2", projectID, from.ID)
+	if err != nil {
+		return result, err
+	}
+
+	fromID, toID := strconv.Itoa(from.ID), strconv.Itoa(to.ID)
+	history, err := json.Marshal(map[string]TaskChange{"empl_id": {From: &fromID, To: &toID}})
+	if err != nil {
+		return result, err
+	}
+	for _, taskID := range assigned {
+		if _, err := tx.Exec("UPDATE tasks SET empl_id = $1, version = version + 1 WHERE id = $2", to.ID, taskID); err != nil {
+			return result, err
+		}
+		if _, err := tx.Exec("INSERT INTO task_history (task_id, changes) VALUES ($1, $2)", taskID, string(history)); err != nil {
+			return result, err
+		}
+	}
+	result.AssignedTasks = len(assigned)
+
+	res, err := tx.Exec("UPDATE tasks SET creator_id = $1, version = version + 1 WHERE project_id = $2 AND creator_id = $3", to.ID, projectID, from.ID)
+	if err != nil {
+		return result, err
+	}
+	created, _ := res.RowsAffected()
+	result.CreatedTasks = int(created)
+
+	_, err = tx.Exec("DELETE FROM user_projects WHERE user_id = $1 AND project_id = $2", from.ID, projectID)
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+type reconcileResult struct {
+	reconcileReport
+	Repaired bool `json:"repaired"`
+}
+
+func filesReconcileCommand(args []string) error {
+	fs := newCommandFlags("files reconcile")
+	repair := fs.Bool("repair", false, "queue orphan objects for deletion, remove records without objects and fix reference counts")
+	dryRun := fs.Bool("dry-run", false, "with -repair, only show what would be repaired")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stores, err := newObjectStores()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	report, err := reconcileFiles(ctx, db, stores.files)
+	if err != nil {
+		return err
+	}
+	result := reconcileResult{reconcileReport: report}
+	if *repair && !*dryRun && !report.empty() {
+		if err := repairFiles(ctx, db, stores.files, report); err != nil {
+			return err
+		}
+		result.Repaired = true
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		for _, file := range report.MissingObjects {
+			fmt.Fprintf(w, "missing object\t%s\tfile %d, task %d, %s\n", file.FileUuid, file.ID, file.TaskID, file.Name)
+		}
+		for _, key := range report.OrphanObjects {
+			fmt.Fprintf(w, "orphan object\t%s\n", key)
+		}
+		for _, blob := range report.BlobRefs {
+			fmt.Fprintf(w, "wrong refs\t%s\t%d recorded, %d actual\n", blob.ObjectName, blob.Refs, blob.Actual)
+		}
+		switch {
+		case result.Repaired:
+			fmt.Fprintf(w, "repaired: %s\n", report)
+		case *repair && !report.empty():
+			fmt.Fprintf(w, "%s; dry run, nothing changed\n", report)
+		default:
+			fmt.Fprintf(w, "%s\n", report)
+		}
+	})
+}
+
+type cleanupResult struct {
+	Deleted int `json:"deleted"`
+	Failed  int `json:"failed"`
+	// Задания, которые ещё ждут следующей попытки
+	Pending int `json:"pending"`
+}
+
+func filesCleanupCommand(args []string) error {
+	fs := newCommandFlags("files cleanup")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stores, err := newObjectStores()
+	if err != nil {
+		return err
+	}
+
+	var result cleanupResult
+	for {
+		deleted, failed, err := cleanupObjects(context.Background(), db, stores)
+		if err != nil {
+			return err
+		}
+		result.Deleted += deleted
+		result.Failed += failed
+		if deleted+failed < cleanupBatch {
+			break
+		}
+	}
+	if err := db.Get(&result.Pending, "SELECT COUNT(*) FROM object_deletions"); err != nil {
+		return err
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted\t%d\n", result.Deleted)
+		fmt.Fprintf(w, "failed\t%d\n", result.Failed)
+		fmt.Fprintf(w, "pending\t%d\n", result.Pending)
+	})
+}
+
+type scanResult struct {
+	Scanned  int `json:"scanned"`
+	Infected int `json:"infected"`
+	// Файлы в карантине, которые ещё ждут проверки
+	Pending int `json:"pending"`
+}
+
+func filesScanCommand(args []string) error {
+	fs := newCommandFlags("files scan")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	scanner, err := newScanner()
+	if err != nil {
+		return err
+	}
+	if scanner == nil {
+		return errors.New("files scan: SCANNER_DRIVER is not set")
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stores, err := newObjectStores()
+	if err != nil {
+		return err
+	}
+
+	var result scanResult
+	for {
+		scanned, infected, err := scanFiles(context.Background(), db, stores.files, scanner)
+		if err != nil {
+			return err
+		}
+		result.Scanned += scanned
+		result.Infected += infected
+		if scanned < scanBatch {
+			break
+		}
+	}
+	if err := db.Get(&result.Pending, "SELECT COUNT(*) FROM files WHERE scan_status = $1", scanPending); err != nil {
+		return err
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "scanned\t%d\n", result.Scanned)
+		fmt.Fprintf(w, "infected\t%d\n", result.Infected)
+		fmt.Fprintf(w, "pending\t%d\n", result.Pending)
+	})
+}
+
+type seedResult struct {
+	Users   []User         `json:"users"`
+	Project Project        `json:"project"`
+	Tasks   []TaskResponse `json:"tasks"`
+}
+
+// Демо-данные для локальной разработки. Пароль у всех пользователей demo.
+func seedCommand(args []string) error {
+	fs := newCommandFlags("seed")
+	if _, err := fs.parse(args, 0); err != nil {
+		return err
+	}
+
+	db, err := openMigratedDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := seed(db)
+	if err != nil {
+		return err
+	}
+
+	return fs.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "project %s (id %d)\n", result.Project.Name, result.Project.ID)
+		for _, user := range result.Users {
+			fmt.Fprintf(w, "user\t%s\tpassword demo\n", user.Login)
+		}
+		fmt.Fprintf(w, "%d tasks\n", len(result.Tasks))
+	})
+}
+
+// Всё или ничего: при ошибке база остаётся пустой, и seed можно повторить
+func seed(db *sqlx.DB) (seedResult, error) {
+	var result seedResult
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for _, user := range []User{
+		{Name: "Demo Manager", Login: "demo", Role: "manager"},
+		{Name: "Alice", Login: "alice", Role: "developer"},
+		{Name: "Bob", Login: "bob", Role: "designer"},
+	} {
+		user.Password = "demo"
+		created, err := insertUser(tx, user)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return result, fmt.Errorf("login %q already exists, the database is already seeded", user.Login)
+			}
+			return result, err
+		}
+		result.Users = append(result.Users, created)
+	}
+
+	project, err := createProject(tx, NewProject{Name: "Demo project", Logins: []string{"demo", "alice", "bob"}})
+	if err != nil {
+		return result, err
+	}
+	result.Project = project
+
+	for _, column := range []string{"To Do", "In Progress", "Done"} {
+		if _, err := appendColumn(tx, project.ID, Column{Name: column}); err != nil {
+			return result, err
+		}
+	}
+
+	creator := result.Users[0].ID
+	for _, task := range []Task{
+		{Name: "Set up the board", Status: "Done", Empl_id: sql.NullString{String: strconv.Itoa(creator), Valid: true}},
+		{Name: "Design the landing page", Status: "In Progress", Empl_id: sql.NullString{String: strconv.Itoa(result.Users[2].ID), Valid: true}},
+		{Name: "Write the API client", Status: "To Do", Empl_id: sql.NullString{String: strconv.Itoa(result.Users[1].ID), Valid: true}},
+		{Name: "Prepare the grant report", Status: "To Do", Priority: sql.NullString{String: "high", Valid: true}},
+	} {
+		task.Date = "2024-06-01"
+		task.Project_id = project.ID
+		task.Creator_id = creator
+		created, err := insertTask(tx, task)
+		if err != nil {
+			return result, err
+		}
+		result.Tasks = append(result.Tasks, created)
+	}
+
+	if _, err := insertGrant(tx, strconv.Itoa(project.ID), Grant{Name: "Demo grant", Descr: "Funding for the demo project", Num: 100000}); err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
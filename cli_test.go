@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSeedIsAtomic(t *testing.T) {
+	db := newTestDB(t)
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM "+table); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Проект с тем же именем ломает seed после вставки пользователей
+	if _, err := db.Exec("INSERT INTO projects (name) VALUES ('Demo project')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed(db); err != errProjectExists {
+		t.Fatalf("seed over an existing project: %v, want errProjectExists", err)
+	}
+	if n := count("users"); n != 0 {
+		t.Fatalf("failed seed left %d users", n)
+	}
+
+	if _, err := db.Exec("DELETE FROM projects"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := seed(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Users) != 3 || len(result.Tasks) != 4 || count("tasks") != 4 {
+		t.Fatalf("seeded %d users and %d tasks", len(result.Users), len(result.Tasks))
+	}
+
+	if _, err := seed(db); err == nil || !strings.Contains(err.Error(), "already seeded") {
+		t.Fatalf("second seed: %v, want already seeded", err)
+	}
+	if n := count("users"); n != 3 {
+		t.Errorf("second seed left %d users, want 3", n)
+	}
+}
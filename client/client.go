@@ -0,0 +1,285 @@
+// Package client — Go клиент для /api/v1.
+//
+//	c := client.New("http://localhost:8080", client.WithToken(token), client.WithUserID(userID))
+//	tasks, err := c.ListProjectTasks(ctx, projectID)
+//
+// Все методы принимают context. Запросы, которые безопасно повторить
+// (GET, PUT, DELETE и создание с Idempotency-Key), повторяются при сетевых
+// ошибках и ответах 429, 502, 503, 504.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	mu     sync.RWMutex
+	token  string
+	userID int
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// Токен отправляется в заголовке Authorization: Bearer
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// Пользователь, от имени которого выполняются запросы, передаётся в X-User-ID.
+// Нужен для скачивания вложений.
+func WithUserID(userID int) Option {
+	return func(c *Client) {
+		c.userID = userID
+	}
+}
+
+// retries — сколько раз повторить запрос после первой попытки,
+// backoff — пауза перед первым повтором, дальше она удваивается
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retries:    2,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Меняет токен для следующих запросов, можно вызывать из разных горутин
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// Меняет пользователя для следующих запросов, можно вызывать из разных горутин
+func (c *Client) SetUserID(userID int) {
+	c.mu.Lock()
+	c.userID = userID
+	c.mu.Unlock()
+}
+
+func (c *Client) UserID() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userID
+}
+
+// Ошибка, которую вернул сервер
+type Error struct {
+	StatusCode int
+	Message    string
+	// Текущее состояние ресурса при 412 Precondition Failed
+	Current json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("justintime: %d %s", e.StatusCode, e.Message)
+}
+
+// Ресурс изменили после того, как клиент прочитал его версию
+func IsPreconditionFailed(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusPreconditionFailed
+}
+
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+type request struct {
+	method      string
+	path        string
+	query       map[string]string
+	body        []byte
+	contentType string
+	headers     map[string]string
+	// Версия ресурса для If-Match, 0 — без проверки
+	version int
+	// Для POST создания: ключ идемпотентности делает повтор безопасным
+	idempotent bool
+}
+
+func jsonRequest(method string, path string, body interface{}) (request, error) {
+	req := request{method: method, path: path}
+	if body == nil {
+		return req, nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return req, err
+	}
+	req.body = data
+	req.contentType = "application/json"
+	return req, nil
+}
+
+// Выполняет запрос и декодирует JSON ответ в out, если out не nil
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, out)
+}
+
+// Отправляет запрос с повторами и возвращает последний ответ
+func (c *Client) roundTrip(ctx context.Context, req request) (*http.Response, error) {
+	retryable := req.idempotent || req.method == http.MethodGet || req.method == http.MethodPut || req.method == http.MethodDelete
+
+	idempotencyKey := ""
+	if req.idempotent {
+		idempotencyKey = uuid.New().String()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, idempotencyKey)
+		if err == nil && !(retryable && retryStatus(resp.StatusCode)) {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if !retryable || attempt >= c.retries {
+			return resp, err
+		}
+
+		wait := backoff
+		if resp != nil {
+			if after, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(after) * time.Second
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, idempotencyKey string) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.query) > 0 {
+		query := httpReq.URL.Query()
+		for key, value := range req.query {
+			query.Set(key, value)
+		}
+		httpReq.URL.RawQuery = query.Encode()
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if token := c.Token(); token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	if userID := c.UserID(); userID != 0 {
+		httpReq.Header.Set("X-User-ID", strconv.Itoa(userID))
+	}
+	for key, value := range req.headers {
+		httpReq.Header.Set(key, value)
+	}
+	if req.version > 0 {
+		httpReq.Header.Set("If-Match", strconv.Quote(strconv.Itoa(req.version)))
+	}
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	return c.httpClient.Do(httpReq)
+}
+
+func retryStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		var body struct {
+			Error   string          `json:"error"`
+			Current json.RawMessage `json:"current"`
+		}
+		if json.Unmarshal(data, &body) != nil || body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: body.Error, Current: body.Current}
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
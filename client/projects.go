@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"justintime-backend/api"
+)
+
+// GET /api/v1/projects?ids=1,2 — названия проектов по id
+func (c *Client) ListProjects(ctx context.Context, ids ...int) (map[int]string, error) {
+	params := make([]string, len(ids))
+	for i, id := range ids {
+		params[i] = strconv.Itoa(id)
+	}
+
+	var result struct {
+		Projects map[int]string `json:"projects"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/projects", query: map[string]string{"ids": strings.Join(params, ",")}}, &result)
+	return result.Projects, err
+}
+
+// POST /api/v1/projects
+func (c *Client) CreateProject(ctx context.Context, project api.NewProject) (api.Project, error) {
+	var created api.Project
+	req, err := jsonRequest(http.MethodPost, "/api/v1/projects", project)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+// GET /api/v1/projects/:id
+func (c *Client) GetProject(ctx context.Context, id int) (api.Project, error) {
+	var project api.Project
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d", id)}, &project)
+	return project, err
+}
+
+// PATCH /api/v1/projects/:id
+// version — известная клиенту версия проекта, 0 отключает проверку
+func (c *Client) RenameProject(ctx context.Context, id int, name string, version int) error {
+	req, err := jsonRequest(http.MethodPatch, fmt.Sprintf("/api/v1/projects/%d", id), api.NewProject{Name: name})
+	if err != nil {
+		return err
+	}
+	req.version = version
+	return c.do(ctx, req, nil)
+}
+
+// GET /api/v1/projects/:id/storage
+func (c *Client) ProjectStorage(ctx context.Context, id int) (api.ProjectStorage, error) {
+	var storage api.ProjectStorage
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/storage", id)}, &storage)
+	return storage, err
+}
+
+// DELETE /api/v1/projects/:id
+func (c *Client) DeleteProject(ctx context.Context, id int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/projects/%d", id)}, nil)
+}
+
+// Доска проекта. Columns в том виде, как их отдаёт сервер.
+type ProjectTasks struct {
+	Columns []string           `json:"columns"`
+	Tasks   []api.TaskResponse `json:"tasks"`
+}
+
+// GET /api/v1/projects/:id/tasks
+func (c *Client) ListProjectTasks(ctx context.Context, projectID int) (ProjectTasks, error) {
+	var result ProjectTasks
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/tasks", projectID)}, &result)
+	return result, err
+}
+
+// POST /api/v1/projects/:id/tasks
+func (c *Client) CreateTask(ctx context.Context, projectID int, task api.Task) (api.TaskResponse, error) {
+	var created api.TaskResponse
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("/api/v1/projects/%d/tasks", projectID), task)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+func columnPath(projectID int, columnID int) string {
+	return fmt.Sprintf("/api/v1/projects/%d/columns/%d", projectID, columnID)
+}
+
+// GET /api/v1/projects/:id/columns
+func (c *Client) ListColumns(ctx context.Context, projectID int) ([]api.Column, error) {
+	var result struct {
+		Columns []api.Column `json:"columns"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/columns", projectID)}, &result)
+	return result.Columns, err
+}
+
+// POST /api/v1/projects/:id/columns, колонка добавляется в конец доски
+func (c *Client) CreateColumn(ctx context.Context, projectID int, column api.Column, version int) (api.Column, error) {
+	var created api.Column
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("/api/v1/projects/%d/columns", projectID), column)
+	if err != nil {
+		return created, err
+	}
+	req.version = version
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+func (c *Client) AddColumn(ctx context.Context, projectID int, name string, version int) error {
+	_, err := c.CreateColumn(ctx, projectID, api.Column{Name: name}, version)
+	return err
+}
+
+// PATCH /api/v1/projects/:id/columns/:columnId, пустые поля patch не меняются
+func (c *Client) UpdateColumn(ctx context.Context, projectID int, columnID int, patch api.Column, version int) (api.Column, error) {
+	var updated api.Column
+	req, err := jsonRequest(http.MethodPatch, columnPath(projectID, columnID), patch)
+	if err != nil {
+		return updated, err
+	}
+	req.version = version
+	err = c.do(ctx, req, &updated)
+	return updated, err
+}
+
+func (c *Client) RenameColumn(ctx context.Context, projectID int, columnID int, newName string, version int) error {
+	_, err := c.UpdateColumn(ctx, projectID, columnID, api.Column{Name: newName}, version)
+	return err
+}
+
+// POST /api/v1/projects/:id/columns/:columnId/move, возвращает колонки в новом порядке
+func (c *Client) MoveColumn(ctx context.Context, projectID int, columnID int, position int, version int) ([]api.Column, error) {
+	var result struct {
+		Columns []api.Column `json:"columns"`
+	}
+	req, err := jsonRequest(http.MethodPost, columnPath(projectID, columnID)+"/move", api.ColumnMove{Position: position})
+	if err != nil {
+		return nil, err
+	}
+	req.version = version
+	err = c.do(ctx, req, &result)
+	return result.Columns, err
+}
+
+// PUT /api/v1/projects/:id/columns/order, ids — id всех колонок слева направо
+func (c *Client) ReorderColumns(ctx context.Context, projectID int, ids []int, version int) ([]api.Column, error) {
+	var result struct {
+		Columns []api.Column `json:"columns"`
+	}
+	req, err := jsonRequest(http.MethodPut, fmt.Sprintf("/api/v1/projects/%d/columns/order", projectID), api.ColumnOrder{Columns: ids})
+	if err != nil {
+		return nil, err
+	}
+	req.version = version
+	err = c.do(ctx, req, &result)
+	return result.Columns, err
+}
+
+// DELETE /api/v1/projects/:id/columns/:columnId?cascade=true — удаляет колонку
+// вместе с её задачами. Колонку с задачами можно восстановить из корзины.
+func (c *Client) DeleteColumn(ctx context.Context, projectID int, columnID int, version int) error {
+	_, err := c.deleteColumn(ctx, projectID, columnID, map[string]string{"cascade": "true"}, version)
+	return err
+}
+
+// DELETE /api/v1/projects/:id/columns/:columnId?target_id=... — удаляет колонку,
+// перенося её задачи в колонку targetID
+func (c *Client) DeleteColumnMovingTasks(ctx context.Context, projectID int, columnID int, targetID int, version int) (api.ColumnTrash, error) {
+	return c.deleteColumn(ctx, projectID, columnID, map[string]string{"target_id": strconv.Itoa(targetID)}, version)
+}
+
+func (c *Client) deleteColumn(ctx context.Context, projectID int, columnID int, query map[string]string, version int) (api.ColumnTrash, error) {
+	var trashed api.ColumnTrash
+	err := c.do(ctx, request{method: http.MethodDelete, path: columnPath(projectID, columnID), query: query, version: version}, &trashed)
+	return trashed, err
+}
+
+// GET /api/v1/projects/:id/trash
+func (c *Client) ListColumnTrash(ctx context.Context, projectID int) ([]api.ColumnTrash, error) {
+	var result struct {
+		Columns []api.ColumnTrash `json:"columns"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/trash", projectID)}, &result)
+	return result.Columns, err
+}
+
+// POST /api/v1/projects/:id/trash/:trashId/restore
+func (c *Client) RestoreColumn(ctx context.Context, projectID int, trashID int, version int) (api.ColumnRestore, error) {
+	var restored api.ColumnRestore
+	err := c.do(ctx, request{method: http.MethodPost, path: fmt.Sprintf("/api/v1/projects/%d/trash/%d/restore", projectID, trashID), version: version}, &restored)
+	return restored, err
+}
+
+// GET /api/v1/projects/:id/members
+func (c *Client) ListMembers(ctx context.Context, projectID int, onlineOnly bool) ([]api.User, error) {
+	req := request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/members", projectID)}
+	if onlineOnly {
+		req.query = map[string]string{"status": "online"}
+	}
+
+	var result struct {
+		Users []api.User `json:"users"`
+	}
+	err := c.do(ctx, req, &result)
+	return result.Users, err
+}
+
+// POST /api/v1/projects/:id/members
+func (c *Client) AddMember(ctx context.Context, projectID int, login string) (api.User, error) {
+	var member api.User
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("/api/v1/projects/%d/members", projectID), api.User{Login: login})
+	if err != nil {
+		return member, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &member)
+	return member, err
+}
+
+// DELETE /api/v1/projects/:id/members/:userId
+func (c *Client) RemoveMember(ctx context.Context, projectID int, userID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/projects/%d/members/%d", projectID, userID)}, nil)
+}
+
+// GET /api/v1/projects/:id/grants
+func (c *Client) ListGrants(ctx context.Context, projectID int) ([]api.Grant, error) {
+	var result struct {
+		Grants []api.Grant `json:"grants"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/grants", projectID)}, &result)
+	return result.Grants, err
+}
+
+// POST /api/v1/projects/:id/grants
+func (c *Client) AddGrant(ctx context.Context, projectID int, grant api.Grant) (api.Grant, error) {
+	var created api.Grant
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("/api/v1/projects/%d/grants", projectID), grant)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+// GET /api/v1/projects/:id/grants/:grantId
+func (c *Client) GetGrant(ctx context.Context, projectID int, grantID string) (api.Grant, error) {
+	var grant api.Grant
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/projects/%d/grants/%s", projectID, url.PathEscape(grantID))}, &grant)
+	return grant, err
+}
+
+// PATCH /api/v1/projects/:id/grants/:grantId
+// grant.Version используется для If-Match, 0 отключает проверку
+func (c *Client) UpdateGrant(ctx context.Context, projectID int, grant api.Grant) (api.Grant, error) {
+	var updated api.Grant
+	req, err := jsonRequest(http.MethodPatch, fmt.Sprintf("/api/v1/projects/%d/grants/%s", projectID, url.PathEscape(grant.ID)), grant)
+	if err != nil {
+		return updated, err
+	}
+	req.version = grant.Version
+	err = c.do(ctx, req, &updated)
+	return updated, err
+}
+
+// DELETE /api/v1/projects/:id/grants/:grantId
+func (c *Client) DeleteGrant(ctx context.Context, projectID int, grantID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/projects/%d/grants/%s", projectID, url.PathEscape(grantID))}, nil)
+}
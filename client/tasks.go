@@ -0,0 +1,398 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"justintime-backend/api"
+)
+
+// GET /api/v1/tasks/:id
+func (c *Client) GetTask(ctx context.Context, id int) (api.TaskResponse, error) {
+	var result struct {
+		Task api.TaskResponse `json:"task"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/tasks/%d", id)}, &result)
+	return result.Task, err
+}
+
+// PATCH /api/v1/tasks/:id в формате merge patch (RFC 7386).
+// Значение nil сбрасывает поле. version — известная клиенту версия задачи,
+// 0 отключает проверку.
+func (c *Client) PatchTask(ctx context.Context, id int, patch map[string]interface{}, version int) (api.TaskResponse, error) {
+	var updated api.TaskResponse
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return updated, err
+	}
+	req := request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/api/v1/tasks/%d", id),
+		body:        body,
+		contentType: "application/merge-patch+json",
+		version:     version,
+	}
+	err = c.do(ctx, req, &updated)
+	return updated, err
+}
+
+func (c *Client) UpdateTaskStatus(ctx context.Context, id int, status string, version int) (api.TaskResponse, error) {
+	return c.PatchTask(ctx, id, map[string]interface{}{"status": status}, version)
+}
+
+// Назначает задачу участнику проекта
+func (c *Client) AssignTask(ctx context.Context, id int, userID int, version int) (api.TaskResponse, error) {
+	return c.PatchTask(ctx, id, map[string]interface{}{"empl_id": strconv.Itoa(userID)}, version)
+}
+
+func (c *Client) UpdateTaskInfo(ctx context.Context, id int, info api.TaskInfo, version int) (api.TaskResponse, error) {
+	return c.PatchTask(ctx, id, map[string]interface{}{"name": info.Name, "descr": info.Descr}, version)
+}
+
+func (c *Client) UpdateTaskPriority(ctx context.Context, id int, priority string, version int) (api.TaskResponse, error) {
+	return c.PatchTask(ctx, id, map[string]interface{}{"priority": priority}, version)
+}
+
+// GET /api/v1/tasks/:id/history
+func (c *Client) TaskHistory(ctx context.Context, id int) ([]api.TaskHistoryEntry, error) {
+	var result struct {
+		History []api.TaskHistoryEntry `json:"history"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/tasks/%d/history", id)}, &result)
+	return result.History, err
+}
+
+// DELETE /api/v1/tasks/:id
+func (c *Client) DeleteTask(ctx context.Context, id int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/tasks/%d", id)}, nil)
+}
+
+// POST /api/v1/tasks/:id/files
+func (c *Client) UploadTaskFile(ctx context.Context, taskID int, fileName string, content io.Reader) (api.File, error) {
+	return c.uploadTaskFile(ctx, taskID, fileName, content, nil)
+}
+
+// POST /api/v1/tasks/:id/files с полем replaces: загружает новую версию файла fileID
+func (c *Client) UploadTaskFileVersion(ctx context.Context, taskID int, fileID int, fileName string, content io.Reader) (api.File, error) {
+	return c.uploadTaskFile(ctx, taskID, fileName, content, map[string]string{"replaces": strconv.Itoa(fileID)})
+}
+
+func (c *Client) uploadTaskFile(ctx context.Context, taskID int, fileName string, content io.Reader, fields map[string]string) (api.File, error) {
+	var created api.File
+	req, err := multipartRequest(http.MethodPost, fmt.Sprintf("/api/v1/tasks/%d/files", taskID), "file", fileName, content, fields)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+// POST /api/v1/tasks/:id/files/uploads
+// Возвращает ссылку, по которой файл загружается прямо в хранилище.
+func (c *Client) CreateDirectUpload(ctx context.Context, taskID int, upload api.NewDirectUpload) (api.DirectUpload, error) {
+	var created api.DirectUpload
+	req, err := jsonRequest(http.MethodPost, fmt.Sprintf("/api/v1/tasks/%d/files/uploads", taskID), upload)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+// POST /api/v1/tasks/:id/files/uploads/:uploadId/confirm
+func (c *Client) ConfirmDirectUpload(ctx context.Context, taskID int, uploadID string) (api.File, error) {
+	var file api.File
+	req := request{method: http.MethodPost, path: fmt.Sprintf("/api/v1/tasks/%d/files/uploads/%s/confirm", taskID, uploadID), idempotent: true}
+	err := c.do(ctx, req, &file)
+	return file, err
+}
+
+// Загружает файл прямо в хранилище: получает ссылку, отправляет по ней
+// content и подтверждает загрузку. content читается дважды: для SHA-256
+// и для отправки.
+func (c *Client) UploadTaskFileDirect(ctx context.Context, taskID int, fileName string, contentType string, content io.ReadSeeker) (api.File, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return api.File{}, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return api.File{}, err
+	}
+
+	upload, err := c.CreateDirectUpload(ctx, taskID, api.NewDirectUpload{Name: fileName, Size: size, ContentType: contentType, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	if err != nil {
+		return api.File{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, upload.Method, upload.UploadURL, content)
+	if err != nil {
+		return api.File{}, err
+	}
+	httpReq.ContentLength = size
+	for key, value := range upload.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return api.File{}, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return api.File{}, &Error{StatusCode: resp.StatusCode, Message: "object store rejected the upload"}
+	}
+
+	return c.ConfirmDirectUpload(ctx, taskID, upload.ID)
+}
+
+// Открытое вложение задачи. Body нужно закрыть.
+type Download struct {
+	Body        io.ReadCloser
+	FileName    string
+	ContentType string
+	Size        int64
+}
+
+// GET /api/v1/tasks/:id/files/:fileId
+// byteRange — значение заголовка Range, например "bytes=0-1023", пустая строка — весь файл.
+// Требует WithUserID: сервер проверяет, что пользователь состоит в проекте задачи.
+func (c *Client) DownloadTaskFile(ctx context.Context, taskID int, fileID int, byteRange string) (Download, error) {
+	return c.download(ctx, fmt.Sprintf("/api/v1/tasks/%d/files/%d", taskID, fileID), byteRange)
+}
+
+// GET /api/v1/tasks/:id/files/:fileId/thumbnails/:size
+// size — один из ключей File.Thumbnails: 64, 256 или 1024.
+// Требует WithUserID, как и DownloadTaskFile.
+func (c *Client) DownloadThumbnail(ctx context.Context, taskID int, fileID int, size int) (Download, error) {
+	return c.download(ctx, fmt.Sprintf("/api/v1/tasks/%d/files/%d/thumbnails/%d", taskID, fileID, size), "")
+}
+
+func (c *Client) download(ctx context.Context, path string, byteRange string) (Download, error) {
+	req := request{method: http.MethodGet, path: path}
+	if byteRange != "" {
+		req.headers = map[string]string{"Range": byteRange}
+	}
+
+	resp, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return Download{}, err
+	}
+	if resp.StatusCode >= 400 {
+		return Download{}, decodeResponse(resp, nil)
+	}
+
+	download := Download{Body: resp.Body, ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		download.FileName = params["filename"]
+	}
+	return download, nil
+}
+
+// DELETE /api/v1/tasks/:id/files/:fileId
+// Требует WithUserID, как и DownloadTaskFile.
+func (c *Client) DeleteTaskFile(ctx context.Context, taskID int, fileID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/tasks/%d/files/%d", taskID, fileID)}, nil)
+}
+
+// Возобновляемая загрузка вложения по протоколу tus
+type ResumableUpload struct {
+	// Адрес загрузки, по нему её можно продолжить и после перезапуска клиента
+	Location string
+	Offset   int64
+	Length   int64
+	// Id вложения, когда загрузка завершена
+	FileID int
+}
+
+func (u ResumableUpload) Completed() bool {
+	return u.FileID != 0
+}
+
+const tusVersion = "1.0.0"
+
+// POST /api/v1/tasks/:id/files/resumable
+// replaces — id файла, новой версией которого станет загрузка, 0 — новый файл.
+func (c *Client) CreateResumableUpload(ctx context.Context, taskID int, fileName string, size int64, replaces int) (ResumableUpload, error) {
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte(fileName))
+	if replaces != 0 {
+		metadata += ",replaces " + base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(replaces)))
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   fmt.Sprintf("/api/v1/tasks/%d/files/resumable", taskID),
+		headers: map[string]string{
+			"Tus-Resumable":   tusVersion,
+			"Upload-Length":   strconv.FormatInt(size, 10),
+			"Upload-Metadata": metadata,
+		},
+	}
+	resp, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return ResumableUpload{}, err
+	}
+	if resp.StatusCode >= 400 {
+		return ResumableUpload{}, decodeResponse(resp, nil)
+	}
+	resp.Body.Close()
+
+	upload := ResumableUpload{Location: resp.Header.Get("Location"), Length: size}
+	if size == 0 {
+		upload.FileID = fileLocationID(resp.Header)
+	}
+	return upload, nil
+}
+
+// HEAD /api/v1/resumable/:uploadId
+func (c *Client) ResumableUploadStatus(ctx context.Context, location string) (ResumableUpload, error) {
+	req := request{method: http.MethodHead, path: location, headers: map[string]string{"Tus-Resumable": tusVersion}}
+	resp, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return ResumableUpload{}, err
+	}
+	if resp.StatusCode >= 400 {
+		return ResumableUpload{}, decodeResponse(resp, nil)
+	}
+	resp.Body.Close()
+
+	upload := ResumableUpload{Location: location, FileID: fileLocationID(resp.Header)}
+	upload.Offset, _ = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	upload.Length, _ = strconv.ParseInt(resp.Header.Get("Upload-Length"), 10, 64)
+	return upload, nil
+}
+
+// PATCH /api/v1/resumable/:uploadId
+// Отправляет content с позиции upload.Offset кусками по chunkSize байт.
+// После сетевой ошибки или расхождения смещений узнаёт у сервера, сколько
+// байт дошло, и продолжает с этого места. По завершении заполняет upload.FileID.
+func (c *Client) ResumeUpload(ctx context.Context, upload *ResumableUpload, content io.ReaderAt, chunkSize int) error {
+	chunk := make([]byte, chunkSize)
+	failures := 0
+	for upload.Offset < upload.Length {
+		n, err := content.ReadAt(chunk[:min(int64(chunkSize), upload.Length-upload.Offset)], upload.Offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		patchErr := c.patchUpload(ctx, upload, chunk[:n])
+		if patchErr == nil {
+			failures = 0
+			continue
+		}
+		if apiErr, ok := patchErr.(*Error); ok && apiErr.StatusCode != http.StatusConflict {
+			return patchErr
+		}
+		if ctx.Err() != nil || failures >= c.retries {
+			return patchErr
+		}
+		failures++
+
+		status, err := c.ResumableUploadStatus(ctx, upload.Location)
+		if err != nil {
+			return err
+		}
+		*upload = status
+	}
+	return nil
+}
+
+func (c *Client) patchUpload(ctx context.Context, upload *ResumableUpload, chunk []byte) error {
+	req := request{
+		method:      http.MethodPatch,
+		path:        upload.Location,
+		body:        chunk,
+		contentType: "application/offset+octet-stream",
+		headers: map[string]string{
+			"Tus-Resumable": tusVersion,
+			"Upload-Offset": strconv.FormatInt(upload.Offset, 10),
+		},
+	}
+	resp, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return decodeResponse(resp, nil)
+	}
+	resp.Body.Close()
+
+	upload.Offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	upload.FileID = fileLocationID(resp.Header)
+	return err
+}
+
+// DELETE /api/v1/resumable/:uploadId
+func (c *Client) CancelResumableUpload(ctx context.Context, location string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: location, headers: map[string]string{"Tus-Resumable": tusVersion}}, nil)
+}
+
+// Id вложения из File-Location: /api/v1/tasks/:id/files/:fileId
+func fileLocationID(header http.Header) int {
+	location := header.Get("File-Location")
+	id, _ := strconv.Atoi(location[strings.LastIndex(location, "/")+1:])
+	return id
+}
+
+// GET /api/v1/tasks/:id/files/:fileId/versions
+// Версии файла от последней к первой. Требует WithUserID.
+func (c *Client) ListFileVersions(ctx context.Context, taskID int, fileID int) ([]api.File, error) {
+	var result struct {
+		Versions []api.File `json:"versions"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/tasks/%d/files/%d/versions", taskID, fileID)}, &result)
+	return result.Versions, err
+}
+
+// POST /api/v1/tasks/:id/files/:fileId/restore
+// Делает версию fileID текущей, добавляя её копию новой версией.
+func (c *Client) RestoreFileVersion(ctx context.Context, taskID int, fileID int) (api.File, error) {
+	var restored api.File
+	err := c.do(ctx, request{method: http.MethodPost, path: fmt.Sprintf("/api/v1/tasks/%d/files/%d/restore", taskID, fileID)}, &restored)
+	return restored, err
+}
+
+// DELETE /api/v1/tasks/:id/files/:fileId/versions?keep=N
+// Удаляет старые версии, оставляя keep последних. Возвращает число удалённых.
+func (c *Client) PruneFileVersions(ctx context.Context, taskID int, fileID int, keep int) (int, error) {
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	req := request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/tasks/%d/files/%d/versions", taskID, fileID), query: map[string]string{"keep": strconv.Itoa(keep)}}
+	err := c.do(ctx, req, &result)
+	return result.Deleted, err
+}
+
+// Собирает форму с одним файлом и полями fields целиком в памяти, чтобы запрос можно было повторить
+func multipartRequest(method string, path string, field string, fileName string, content io.Reader, fields map[string]string) (request, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return request{}, err
+		}
+	}
+	part, err := form.CreateFormFile(field, fileName)
+	if err != nil {
+		return request{}, err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return request{}, err
+	}
+	if err := form.Close(); err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: body.Bytes(), contentType: form.FormDataContentType()}, nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"justintime-backend/api"
+)
+
+type LoginResult struct {
+	User     api.User `json:"user"`
+	Projects []int    `json:"projects"`
+}
+
+// POST /api/v1/auth/login
+func (c *Client) Login(ctx context.Context, login string, password string) (LoginResult, error) {
+	var result LoginResult
+	req, err := jsonRequest(http.MethodPost, "/api/v1/auth/login", api.User{Login: login, Password: password})
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, req, &result)
+	return result, err
+}
+
+// GET /api/v1/logins/:login
+func (c *Client) LoginAvailable(ctx context.Context, login string) (bool, error) {
+	var result struct {
+		Available bool `json:"available"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/api/v1/logins/" + url.PathEscape(login)}, &result)
+	return result.Available, err
+}
+
+// POST /api/v1/users
+func (c *Client) CreateUser(ctx context.Context, user api.User) (api.User, error) {
+	var created api.User
+	req, err := jsonRequest(http.MethodPost, "/api/v1/users", user)
+	if err != nil {
+		return created, err
+	}
+	req.idempotent = true
+	err = c.do(ctx, req, &created)
+	return created, err
+}
+
+// GET /api/v1/users/:id
+func (c *Client) GetUser(ctx context.Context, id int) (api.User, error) {
+	var result struct {
+		User api.User `json:"user"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/users/%d", id)}, &result)
+	return result.User, err
+}
+
+// PATCH /api/v1/users/:id
+func (c *Client) UpdateUserStatus(ctx context.Context, id int, status string) error {
+	req, err := jsonRequest(http.MethodPatch, fmt.Sprintf("/api/v1/users/%d", id), api.UserPatch{Status: &status})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req, nil)
+}
+
+// PUT /api/v1/users/:id/avatar
+func (c *Client) UpdateAvatar(ctx context.Context, id int, fileName string, image io.Reader) error {
+	req, err := multipartRequest(http.MethodPut, fmt.Sprintf("/api/v1/users/%d/avatar", id), "image", fileName, image, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req, nil)
+}
+
+// DELETE /api/v1/users/:id/avatar, вместо аватара будет identicon
+func (c *Client) ResetAvatar(ctx context.Context, id int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/users/%d/avatar", id)}, nil)
+}
+
+// GET /avatars/:userId, квадратный PNG стороной size: 32, 64, 128 или 256.
+// Ссылку с версией для кэша можно взять из User.Avatar.
+func (c *Client) DownloadAvatar(ctx context.Context, userID int, size int) (Download, error) {
+	return c.download(ctx, fmt.Sprintf("/avatars/%d?size=%d", userID, size), "")
+}
+
+// Проект из списка проектов пользователя
+type UserProject struct {
+	ID   int    `json:"project_id"`
+	Name string `json:"project_name"`
+}
+
+// GET /api/v1/users/:id/projects
+func (c *Client) ListUserProjects(ctx context.Context, id int) ([]UserProject, error) {
+	var result struct {
+		Projects []UserProject `json:"projects"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/users/%d/projects", id)}, &result)
+	return result.Projects, err
+}
+
+// GET /api/v1/users/:id/notifications, последние сначала
+func (c *Client) ListNotifications(ctx context.Context, id int) ([]api.Notification, error) {
+	var result struct {
+		Notifications []api.Notification `json:"notifications"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/users/%d/notifications", id)}, &result)
+	return result.Notifications, err
+}
+
+// GET /api/v1/users/:id/storage
+func (c *Client) UserStorage(ctx context.Context, id int) (api.UserStorage, error) {
+	var storage api.UserStorage
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/api/v1/users/%d/storage", id)}, &storage)
+	return storage, err
+}
+
+// PUT /api/v1/users/:id/projects/:project_id
+func (c *Client) AddUserProject(ctx context.Context, id int, projectID int) error {
+	return c.do(ctx, request{method: http.MethodPut, path: fmt.Sprintf("/api/v1/users/%d/projects/%d", id, projectID)}, nil)
+}
+
+// DELETE /api/v1/users/:id/projects/:project_id
+func (c *Client) RemoveUserProject(ctx context.Context, id int, projectID int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/api/v1/users/%d/projects/%d", id, projectID)}, nil)
+}
@@ -0,0 +1,268 @@
+package main
+
+// Тесты пакета client. Они лежат здесь, а не в client/, потому что поднимают
+// настоящий роутер, а пакет main нельзя импортировать.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Пользователь и проект с колонками To Do и Done
+func newTestBoard(t *testing.T, c *client.Client) (api.User, api.Project) {
+	t.Helper()
+	ctx := context.Background()
+
+	user, err := c.CreateUser(ctx, api.User{Name: "Ann", Login: "ann", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := c.CreateProject(ctx, api.NewProject{Name: "Board", Logins: []string{"ann"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"To Do", "Done"} {
+		if _, err := c.CreateColumn(ctx, project.ID, api.Column{Name: name}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user, project
+}
+
+func TestClientLogin(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+
+	result, err := c.Login(context.Background(), "ann", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.ID != user.ID {
+		t.Errorf("logged in as %d, want %d", result.User.ID, user.ID)
+	}
+	if len(result.Projects) != 1 || result.Projects[0] != project.ID {
+		t.Errorf("projects = %v, want [%d]", result.Projects, project.ID)
+	}
+
+	_, err = c.Login(context.Background(), "ann", "wrong")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: %v, want 401", err)
+	}
+}
+
+func TestClientTasks(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Write tests", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "To Do" || task.Project_id != project.ID {
+		t.Fatalf("created task = %+v", task)
+	}
+
+	updated, err := c.UpdateTaskStatus(ctx, task.ID, "Done", task.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != "Done" || updated.Version != task.Version+1 {
+		t.Fatalf("updated task = %+v", updated)
+	}
+
+	_, err = c.UpdateTaskStatus(ctx, task.ID, "To Do", task.Version)
+	if !client.IsPreconditionFailed(err) {
+		t.Fatalf("update with stale version: %v, want 412", err)
+	}
+
+	file, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "notes.txt" || file.Size != 5 {
+		t.Fatalf("uploaded file = %+v", file)
+	}
+
+	got, err := c.GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != 1 || got.Files[0].ID != file.ID {
+		t.Fatalf("task files = %+v", got.Files)
+	}
+}
+
+// Перед роутером отвечает status на первые fail запросов. Если pass, запрос
+// всё же доходит до роутера, а клиент видит только ошибку.
+func flakyServer(t *testing.T, router http.Handler, fail int32, status int, pass bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= fail {
+			if pass {
+				router.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestClientRetries(t *testing.T) {
+	srv, db := newTestServer(t)
+	user, project := newTestBoard(t, client.New(srv.URL))
+	ctx := context.Background()
+
+	t.Run("GET after 503", func(t *testing.T) {
+		flaky, calls := flakyServer(t, srv.Config.Handler, 2, http.StatusServiceUnavailable, false)
+		c := client.New(flaky.URL, client.WithRetries(2, time.Millisecond))
+
+		if _, err := c.GetProject(ctx, project.ID); err != nil {
+			t.Fatal(err)
+		}
+		if n := calls.Load(); n != 3 {
+			t.Errorf("calls = %d, want 3", n)
+		}
+	})
+
+	t.Run("create after lost response", func(t *testing.T) {
+		// Первый ответ теряется после того, как задача создана: повтор с тем
+		// же Idempotency-Key получает сохранённый ответ, а не вторую задачу
+		flaky, calls := flakyServer(t, srv.Config.Handler, 1, http.StatusBadGateway, true)
+		c := client.New(flaky.URL, client.WithRetries(2, time.Millisecond))
+
+		task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Once", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("calls = %d, want 2", n)
+		}
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM tasks WHERE name = 'Once'"); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 || task.Name != "Once" {
+			t.Errorf("%d tasks created, want 1", count)
+		}
+	})
+
+	t.Run("500 is not retried", func(t *testing.T) {
+		flaky, calls := flakyServer(t, srv.Config.Handler, 1, http.StatusInternalServerError, false)
+		c := client.New(flaky.URL, client.WithRetries(2, time.Millisecond))
+
+		_, err := c.GetProject(ctx, project.ID)
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("err = %v, want 500", err)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("calls = %d, want 1", n)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		flaky, calls := flakyServer(t, srv.Config.Handler, 10, http.StatusServiceUnavailable, false)
+		c := client.New(flaky.URL, client.WithRetries(2, time.Millisecond))
+
+		_, err := c.GetProject(ctx, project.ID)
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("err = %v, want 503", err)
+		}
+		if n := calls.Load(); n != 3 {
+			t.Errorf("calls = %d, want 3", n)
+		}
+	})
+}
+
+func TestClientContextCancel(t *testing.T) {
+	srv, _ := newTestServer(t)
+	_, project := newTestBoard(t, client.New(srv.URL))
+
+	t.Run("during request", func(t *testing.T) {
+		release := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			srv.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer slow.Close()
+		defer close(release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.New(slow.URL).GetProject(ctx, project.ID)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("during backoff", func(t *testing.T) {
+		flaky, calls := flakyServer(t, srv.Config.Handler, 10, http.StatusServiceUnavailable, false)
+		c := client.New(flaky.URL, client.WithRetries(5, time.Hour))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for calls.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		start := time.Now()
+		_, err := c.GetProject(ctx, project.ID)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("returned after %v, backoff was not interrupted", elapsed)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("calls = %d, want 1", n)
+		}
+	})
+}
+
+func TestClientToken(t *testing.T) {
+	var auth atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		w.Write([]byte(`{"project": {"id": 1}}`))
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithToken("first"))
+	if _, err := c.GetProject(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := auth.Load(); got != "Bearer first" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer first")
+	}
+
+	c.SetToken("second")
+	if _, err := c.GetProject(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := auth.Load(); got != "Bearer second" {
+		t.Errorf("Authorization after SetToken = %q, want %q", got, "Bearer second")
+	}
+}
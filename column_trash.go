@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Удалённая колонка попадает в корзину column_trash и до expires_at её можно
+// восстановить. Задачи колонки либо переносятся в другую колонку (в корзине
+// остаются их id, target_column_id указывает, куда), либо удаляются вместе с
+// ней: тогда в trashed_tasks хранится снимок каждой задачи, а файлы задач
+// остаются на месте и учитываются в квоте до очистки корзины.
+
+const columnTrashRetention = 30 * 24 * time.Hour
+
+const columnTrashFields = "id, column_id, name, position, color, category, COALESCE(target_column_id, 0) AS target_column_id, tasks, deleted_at, expires_at"
+
+type columnTrashRow struct {
+	ID             int       `db:"id"`
+	ColumnID       int       `db:"column_id"`
+	Name           string    `db:"name"`
+	Position       int       `db:"position"`
+	Color          string    `db:"color"`
+	Category       string    `db:"category"`
+	TargetColumnID int       `db:"target_column_id"`
+	Tasks          int       `db:"tasks"`
+	DeletedAt      time.Time `db:"deleted_at"`
+	ExpiresAt      time.Time `db:"expires_at"`
+}
+
+func (row columnTrashRow) response() ColumnTrash {
+	return ColumnTrash{
+		ID: row.ID,
+		Column: Column{
+			ID:       row.ColumnID,
+			Name:     row.Name,
+			Position: row.Position,
+			Color:    row.Color,
+			Category: row.Category,
+		},
+		Tasks:          row.Tasks,
+		TargetColumnID: row.TargetColumnID,
+		DeletedAt:      row.DeletedAt,
+		ExpiresAt:      row.ExpiresAt,
+	}
+}
+
+// Задачи корзины trash_id = $1, удалённые вместе с колонкой
+const trashedTaskQuery = "SELECT task_id FROM trashed_tasks WHERE trash_id = $1 AND task IS NOT NULL"
+
+// Задачи проекта project_id = $1, удалённые вместе с колонками
+const trashedProjectTaskQuery = "SELECT trashed_tasks.task_id FROM trashed_tasks JOIN column_trash ON column_trash.id = trashed_tasks.trash_id WHERE column_trash.project_id = $1 AND trashed_tasks.task IS NOT NULL"
+
+// Удаляет колонку ref в корзину. Задачи переносятся в колонку target, а
+// если она не задана, удаляются вместе с колонкой — но только при cascade.
+func trashColumn(tx *sqlx.Tx, projectID interface{}, ref columnRef, target columnRef, cascade bool) (ColumnTrash, error) {
+	column, err := findColumnRef(tx, projectID, ref)
+	if err == sql.ErrNoRows {
+		return ColumnTrash{}, columnNotFound(ref)
+	}
+	if err != nil {
+		return ColumnTrash{}, err
+	}
+
+	var tasks int
+	if err := tx.Get(&tasks, "SELECT COUNT(*) FROM tasks WHERE column_id = $1", column.ID); err != nil {
+		return ColumnTrash{}, err
+	}
+
+	var targetColumn Column
+	if target != (columnRef{}) {
+		if cascade {
+			return ColumnTrash{}, &updateError{status: http.StatusBadRequest, message: "Use either target or cascade"}
+		}
+		targetColumn, err = findColumnRef(tx, projectID, target)
+		if err == sql.ErrNoRows {
+			return ColumnTrash{}, &updateError{status: http.StatusBadRequest, message: fmt.Sprintf("Target column %s not found", target)}
+		}
+		if err != nil {
+			return ColumnTrash{}, err
+		}
+		if targetColumn.ID == column.ID {
+			return ColumnTrash{}, &updateError{status: http.StatusBadRequest, message: "Tasks cannot be moved into the column being deleted"}
+		}
+	} else if tasks > 0 && !cascade {
+		return ColumnTrash{}, &updateError{status: http.StatusConflict, message: fmt.Sprintf("Column %q has %d tasks: pass target_id to move them or cascade=true to delete them", column.Name, tasks)}
+	}
+
+	now := time.Now().UTC()
+	row := columnTrashRow{
+		ColumnID:       column.ID,
+		Name:           column.Name,
+		Position:       column.Position,
+		Color:          column.Color,
+		Category:       column.Category,
+		TargetColumnID: targetColumn.ID,
+		Tasks:          tasks,
+		DeletedAt:      now,
+		ExpiresAt:      now.Add(columnTrashRetention),
+	}
+	var targetID sql.NullInt64
+	if targetColumn.ID != 0 {
+		targetID = sql.NullInt64{Int64: int64(targetColumn.ID), Valid: true}
+	}
+	err = tx.Get(&row.ID, "INSERT INTO column_trash (project_id, column_id, name, position, color, category, target_column_id, tasks, deleted_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
+		projectID, row.ColumnID, row.Name, row.Position, row.Color, row.Category, targetID, row.Tasks, row.DeletedAt, row.ExpiresAt)
+	if err != nil {
+		return ColumnTrash{}, err
+	}
+
+	if targetColumn.ID != 0 {
+		if _, err := tx.Exec("INSERT INTO trashed_tasks (trash_id, task_id) SELECT $1, id FROM tasks WHERE column_id = $2", row.ID, column.ID); err != nil {
+			return ColumnTrash{}, err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET column_id = $1, status = $2, version = version + 1 WHERE column_id = $3", targetColumn.ID, targetColumn.Name, column.ID); err != nil {
+			return ColumnTrash{}, err
+		}
+	} else if err := snapshotTasks(tx, row.ID, column.ID); err != nil {
+		return ColumnTrash{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM columns WHERE id = $1", column.ID); err != nil {
+		return ColumnTrash{}, err
+	}
+	_, err = tx.Exec("UPDATE columns SET position = position - 1 WHERE project_id = $1 AND position > $2", projectID, column.Position)
+	return row.response(), err
+}
+
+// Переносит задачи колонки в trashed_tasks и удаляет их из tasks
+func snapshotTasks(tx *sqlx.Tx, trashID int, columnID int) error {
+	var tasks []Task
+	if err := tx.Select(&tasks, "SELECT "+taskFields+" FROM tasks WHERE column_id = $1", columnID); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		snapshot, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO trashed_tasks (trash_id, task_id, task) VALUES ($1, $2, $3)", trashID, task.ID, string(snapshot)); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM tasks WHERE column_id = $1", columnID)
+	return err
+}
+
+// Возвращает колонку из корзины на прежнее место вместе с её задачами.
+// Перенесённые задачи возвращаются, только если они ещё в целевой колонке.
+func restoreColumn(tx *sqlx.Tx, projectID interface{}, trashID string) (ColumnRestore, error) {
+	var row columnTrashRow
+	err := tx.Get(&row, "SELECT "+columnTrashFields+" FROM column_trash WHERE id = $1 AND project_id = $2 AND expires_at > $3", trashID, projectID, time.Now().UTC())
+	if err == sql.ErrNoRows {
+		return ColumnRestore{}, &updateError{status: http.StatusNotFound, message: "Column not found in trash"}
+	}
+	if err != nil {
+		return ColumnRestore{}, err
+	}
+
+	exists, err := columnExists(tx, projectID, row.Name)
+	if err != nil {
+		return ColumnRestore{}, err
+	}
+	if exists {
+		return ColumnRestore{}, &updateError{status: http.StatusConflict, message: fmt.Sprintf("Column %q already exists, rename it before restoring", row.Name)}
+	}
+
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM columns WHERE project_id = $1", projectID); err != nil {
+		return ColumnRestore{}, err
+	}
+	column := row.response().Column
+	column.Position = min(row.Position, count)
+	if _, err := tx.Exec("UPDATE columns SET position = position + 1 WHERE project_id = $1 AND position >= $2", projectID, column.Position); err != nil {
+		return ColumnRestore{}, err
+	}
+	_, err = tx.Exec("INSERT INTO columns (id, project_id, name, position, color, category) VALUES ($1, $2, $3, $4, $5, $6)",
+		column.ID, projectID, column.Name, column.Position, column.Color, column.Category)
+	if err != nil {
+		return ColumnRestore{}, err
+	}
+
+	restored := ColumnRestore{Column: column}
+	if row.TargetColumnID != 0 {
+		res, err := tx.Exec("UPDATE tasks SET column_id = $1, status = $2, version = version + 1 WHERE column_id = $3 AND id IN (SELECT task_id FROM trashed_tasks WHERE trash_id = $4)",
+			column.ID, column.Name, row.TargetColumnID, row.ID)
+		if err != nil {
+			return ColumnRestore{}, err
+		}
+		moved, err := res.RowsAffected()
+		if err != nil {
+			return ColumnRestore{}, err
+		}
+		restored.Tasks = int(moved)
+	} else {
+		var snapshots []string
+		if err := tx.Select(&snapshots, "SELECT task FROM trashed_tasks WHERE trash_id = $1 AND task IS NOT NULL ORDER BY task_id", row.ID); err != nil {
+			return ColumnRestore{}, err
+		}
+		for _, snapshot := range snapshots {
+			var task Task
+			if err := json.Unmarshal([]byte(snapshot), &task); err != nil {
+				return ColumnRestore{}, err
+			}
+			_, err = tx.Exec("INSERT INTO tasks (id, name, descr, date, date_act, empl_id, project_id, status, priority, creator_id, version, column_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+				task.ID, task.Name, task.Descr, task.Date, task.Date_act, task.Empl_id, task.Project_id, column.Name, task.Priority, task.Creator_id, task.Version+1, column.ID)
+			if err != nil {
+				return ColumnRestore{}, err
+			}
+		}
+		restored.Tasks = len(snapshots)
+	}
+
+	_, err = tx.Exec("DELETE FROM column_trash WHERE id = $1", row.ID)
+	return restored, err
+}
+
+// Окончательно удаляет колонки, у которых истёк срок хранения в корзине,
+// вместе с файлами их задач
+func expireColumnTrash(db *sqlx.DB) (int, error) {
+	var expired []int
+	err := db.Select(&expired, "SELECT id FROM column_trash WHERE expires_at <= $1 LIMIT $2", time.Now().UTC(), cleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range expired {
+		if err := purgeColumnTrash(db, id); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+func purgeColumnTrash(db *sqlx.DB, trashID int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Запись могли уже удалить другая копия сервера или восстановление
+	var exists bool
+	if err := tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM column_trash WHERE id = $1)", trashID); err != nil || !exists {
+		return err
+	}
+
+	fileQuery := "SELECT id FROM files WHERE task_id IN (" + trashedTaskQuery + ")"
+	// releaseStorage находит проект файла через tasks, а задач корзины там уже нет
+	_, err = tx.Exec("UPDATE projects SET storage_used = storage_used - (SELECT COALESCE(SUM(size), 0) FROM files WHERE id IN ("+fileQuery+")) WHERE id = (SELECT project_id FROM column_trash WHERE id = $1)", trashID)
+	if err != nil {
+		return err
+	}
+	if _, err := deleteFiles(tx, fileQuery, trashID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM column_trash WHERE id = $1", trashID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Удаляет файлы задач, лежащих в корзине проекта; сами записи корзины
+// удаляются вместе с проектом
+func deleteTrashedTaskFiles(tx *sqlx.Tx, projectID interface{}) error {
+	_, err := deleteFiles(tx, "SELECT id FROM files WHERE task_id IN ("+trashedProjectTaskQuery+")", projectID)
+	return err
+}
+
+// DELETE /api/v1/projects/:id/columns/:columnId?target_id=2 или ?cascade=true
+// Параметр target с именем колонки оставлен для старых клиентов
+func columnDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		column := columnParam(c)
+
+		cascade, err := strconv.ParseBool(c.DefaultQuery("cascade", "false"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cascade must be true or false"})
+			return
+		}
+
+		var target columnRef
+		if targetID, ok := c.GetQuery("target_id"); ok {
+			if target.id, err = strconv.Atoi(targetID); err != nil || target.id <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "target_id must be a column id"})
+				return
+			}
+		} else if name := c.Query("target"); name != "" {
+			c.Header("Deprecation", "true")
+			target = columnByName(name)
+		}
+
+		var trashed ColumnTrash
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			var err error
+			trashed, err = trashColumn(tx, id, column, target, cascade)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, trashed)
+	})
+}
+
+// GET /api/v1/projects/:id/trash
+func columnTrashHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var exists bool
+		if err := db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1)", id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+
+		var rows []columnTrashRow
+		err := db.Select(&rows, "SELECT "+columnTrashFields+" FROM column_trash WHERE project_id = $1 AND expires_at > $2 ORDER BY deleted_at DESC, id DESC", id, time.Now().UTC())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		columns := make([]ColumnTrash, 0, len(rows))
+		for _, row := range rows {
+			columns = append(columns, row.response())
+		}
+		c.JSON(http.StatusOK, gin.H{"columns": columns})
+	})
+}
+
+// POST /api/v1/projects/:id/trash/:trashId/restore
+func columnRestoreHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+		trashID := c.Param("trashId")
+
+		var restored ColumnRestore
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			var err error
+			restored, err = restoreColumn(tx, id, trashID)
+			return err
+		})
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, restored)
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Колонки доски хранятся в таблице columns, порядок задаёт position с 0.
+// Задача ссылается на колонку через tasks.column_id, а в tasks.status
+// остаётся имя колонки: его отдаёт API и пишет история изменений. При
+// переименовании колонки status её задач обновляется по column_id.
+
+var columnCategories = []string{"todo", "in_progress", "done"}
+
+const defaultColumnCategory = "todo"
+
+var columnColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+const columnFields = "id, name, position, color, category"
+
+// Проверяет заданные поля колонки, пустые поля не проверяются
+func validateColumn(column Column) error {
+	if column.Color != "" && !columnColorPattern.MatchString(column.Color) {
+		return &updateError{status: http.StatusBadRequest, message: "Color must look like #1e90ff"}
+	}
+	if column.Category != "" && !slices.Contains(columnCategories, column.Category) {
+		return &updateError{status: http.StatusBadRequest, message: fmt.Sprintf("Category must be one of %s", strings.Join(columnCategories, ", "))}
+	}
+	return nil
+}
+
+// Колонка в пути запроса: id или, у старых клиентов, имя
+type columnRef struct {
+	id   int
+	name string
+}
+
+func columnByName(name string) columnRef {
+	return columnRef{name: name}
+}
+
+func (ref columnRef) String() string {
+	if ref.id != 0 {
+		return strconv.Itoa(ref.id)
+	}
+	return strconv.Quote(ref.name)
+}
+
+func columnNotFound(ref columnRef) error {
+	return &updateError{status: http.StatusNotFound, message: fmt.Sprintf("Column %s not found", ref)}
+}
+
+func columnConflict(name string) error {
+	return &updateError{status: http.StatusConflict, message: fmt.Sprintf("Column %q already exists", name)}
+}
+
+// Колонки проекта по порядку
+func listColumns(q sqlx.Queryer, projectID interface{}) ([]Column, error) {
+	columns := []Column{}
+	err := sqlx.Select(q, &columns, "SELECT "+columnFields+" FROM columns WHERE project_id = $1 ORDER BY position", projectID)
+	return columns, err
+}
+
+// Колонка проекта по имени, sql.ErrNoRows, если её нет
+func findColumn(q sqlx.Queryer, projectID interface{}, name string) (Column, error) {
+	var column Column
+	err := sqlx.Get(q, &column, "SELECT "+columnFields+" FROM columns WHERE project_id = $1 AND name = $2", projectID, name)
+	return column, err
+}
+
+// Колонка проекта по ссылке ref, sql.ErrNoRows, если её нет
+func findColumnRef(q sqlx.Queryer, projectID interface{}, ref columnRef) (Column, error) {
+	if ref.id == 0 {
+		return findColumn(q, projectID, ref.name)
+	}
+	var column Column
+	err := sqlx.Get(q, &column, "SELECT "+columnFields+" FROM columns WHERE project_id = $1 AND id = $2", projectID, ref.id)
+	return column, err
+}
+
+func columnExists(q sqlx.Queryer, projectID interface{}, name string) (bool, error) {
+	var exists bool
+	err := sqlx.Get(q, &exists, "SELECT EXISTS (SELECT 1 FROM columns WHERE project_id = $1 AND name = $2)", projectID, name)
+	return exists, err
+}
+
+// Добавляет колонку в конец доски
+func appendColumn(db sqlx.Ext, projectID interface{}, column Column) (Column, error) {
+	column.Name = strings.TrimSpace(column.Name)
+	if column.Name == "" {
+		return Column{}, &updateError{status: http.StatusBadRequest, message: "Column name cannot be empty"}
+	}
+	if err := validateColumn(column); err != nil {
+		return Column{}, err
+	}
+	if column.Category == "" {
+		column.Category = defaultColumnCategory
+	}
+
+	var created Column
+	err := sqlx.Get(db, &created, "INSERT INTO columns (project_id, name, position, color, category) VALUES ($1, $2, (SELECT COUNT(*) FROM columns WHERE project_id = $1), $3, $4) RETURNING "+columnFields,
+		projectID, column.Name, column.Color, column.Category)
+	if isUniqueViolation(err) {
+		return Column{}, columnConflict(column.Name)
+	}
+	return created, err
+}
+
+// Меняет непустые поля patch у колонки ref. Новое имя переносится в status её задач.
+func updateColumn(tx *sqlx.Tx, projectID interface{}, ref columnRef, patch Column) (Column, error) {
+	column, err := findColumnRef(tx, projectID, ref)
+	if err == sql.ErrNoRows {
+		return Column{}, columnNotFound(ref)
+	}
+	if err != nil {
+		return Column{}, err
+	}
+	if err := validateColumn(patch); err != nil {
+		return Column{}, err
+	}
+
+	if newName := strings.TrimSpace(patch.Name); newName != "" && newName != column.Name {
+		if _, err := tx.Exec("UPDATE columns SET name = $1 WHERE id = $2", newName, column.ID); err != nil {
+			if isUniqueViolation(err) {
+				return Column{}, columnConflict(newName)
+			}
+			return Column{}, err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET status = $1, version = version + 1 WHERE column_id = $2", newName, column.ID); err != nil {
+			return Column{}, err
+		}
+		column.Name = newName
+	}
+	if patch.Color != "" {
+		column.Color = patch.Color
+	}
+	if patch.Category != "" {
+		column.Category = patch.Category
+	}
+	_, err = tx.Exec("UPDATE columns SET color = $1, category = $2 WHERE id = $3", column.Color, column.Category, column.ID)
+	return column, err
+}
+
+// Ставит колонку ref на место position, соседние колонки сдвигаются
+func moveColumn(tx *sqlx.Tx, projectID interface{}, ref columnRef, position int) error {
+	column, err := findColumnRef(tx, projectID, ref)
+	if err == sql.ErrNoRows {
+		return columnNotFound(ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM columns WHERE project_id = $1", projectID); err != nil {
+		return err
+	}
+	if position < 0 || position >= count {
+		return &updateError{status: http.StatusBadRequest, message: fmt.Sprintf("Position must be from 0 to %d", count-1)}
+	}
+
+	if position < column.Position {
+		_, err = tx.Exec("UPDATE columns SET position = position + 1 WHERE project_id = $1 AND position >= $2 AND position < $3", projectID, position, column.Position)
+	} else if position > column.Position {
+		_, err = tx.Exec("UPDATE columns SET position = position - 1 WHERE project_id = $1 AND position > $2 AND position <= $3", projectID, column.Position, position)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE columns SET position = $1 WHERE id = $2", position, column.ID)
+	return err
+}
+
+// Расставляет колонки в порядке ids. В ids должна быть каждая колонка проекта ровно один раз.
+func reorderColumns(tx *sqlx.Tx, projectID interface{}, ids []int) error {
+	columns, err := listColumns(tx, projectID)
+	if err != nil {
+		return err
+	}
+	remaining := make(map[int]bool, len(columns))
+	for _, column := range columns {
+		remaining[column.ID] = true
+	}
+	for _, id := range ids {
+		if !remaining[id] {
+			return &updateError{status: http.StatusBadRequest, message: fmt.Sprintf("Column %d is not on the board or is listed twice", id)}
+		}
+		delete(remaining, id)
+	}
+	if len(remaining) > 0 {
+		return &updateError{status: http.StatusBadRequest, message: "The order must list every column of the board"}
+	}
+
+	for position, id := range ids {
+		if _, err := tx.Exec("UPDATE columns SET position = $1 WHERE id = $2", position, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Колонки в том виде, в каком их исторически отдаёт /projects/:id/tasks:
+// один элемент с литералом массива Postgres, например {"To Do",Done}
+func projectColumns(q sqlx.Queryer, projectID interface{}) ([]string, error) {
+	columns, err := listColumns(q, projectID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, column.Name)
+	}
+	literal, err := pq.StringArray(names).Value()
+	if err != nil {
+		return nil, err
+	}
+	return []string{literal.(string)}, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestColumnRoutesByID(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var project Project
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board"}, nil, &project)
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+
+	var slashed, done Column
+	resp := doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "Review/QA"}, nil, &slashed)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create column: status %d", resp.StatusCode)
+	}
+	if want := projectPath + "/columns/" + strconv.Itoa(slashed.ID); resp.Header.Get("Location") != want {
+		t.Errorf("Location = %q, want %q", resp.Header.Get("Location"), want)
+	}
+	doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "Done"}, nil, &done)
+
+	// Имя со слэшем по имени не адресовать, а по id — можно
+	var renamed Column
+	resp = doJSON(t, srv, http.MethodPatch, projectPath+"/columns/"+strconv.Itoa(slashed.ID), Column{Name: "In review"}, nil, &renamed)
+	if resp.StatusCode != http.StatusOK || renamed.Name != "In review" {
+		t.Fatalf("rename by id: status %d, column %+v", resp.StatusCode, renamed)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("request by id is marked deprecated")
+	}
+
+	// Старые клиенты по имени по-прежнему работают
+	resp = doJSON(t, srv, http.MethodPost, projectPath+"/columns/"+url.PathEscape("In review")+"/move", ColumnMove{Position: 1}, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("move by name: status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Deprecation") != "true" {
+		t.Error("request by name is not marked deprecated")
+	}
+
+	resp = doJSON(t, srv, http.MethodPatch, projectPath+"/columns/999", Column{Color: "#ffffff"}, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("patch of a missing column: status %d, want 404", resp.StatusCode)
+	}
+
+	var task TaskResponse
+	doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Ship", Date: "2024-01-01", Status: "In review"}, nil, &task)
+
+	resp = doJSON(t, srv, http.MethodDelete, projectPath+"/columns/"+strconv.Itoa(slashed.ID)+"?target_id="+strconv.Itoa(slashed.ID), nil, nil, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("delete into itself: status %d, want 400", resp.StatusCode)
+	}
+
+	var trashed ColumnTrash
+	resp = doJSON(t, srv, http.MethodDelete, projectPath+"/columns/"+strconv.Itoa(slashed.ID)+"?target_id="+strconv.Itoa(done.ID), nil, nil, &trashed)
+	if resp.StatusCode != http.StatusOK || trashed.TargetColumnID != done.ID {
+		t.Fatalf("delete moving tasks: status %d, trash %+v", resp.StatusCode, trashed)
+	}
+	var moved struct {
+		Task TaskResponse `json:"task"`
+	}
+	doJSON(t, srv, http.MethodGet, "/api/v1/tasks/"+strconv.Itoa(task.ID), nil, nil, &moved)
+	if moved.Task.Column_id != done.ID || moved.Task.Status != "Done" {
+		t.Errorf("moved task = %+v", moved.Task)
+	}
+}
+
+func TestTaskPatchColumnID(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var project, other Project
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board"}, nil, &project)
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Other"}, nil, &other)
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+
+	var todo, done, foreign Column
+	doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "To Do"}, nil, &todo)
+	doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "Done"}, nil, &done)
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects/"+strconv.Itoa(other.ID)+"/columns", Column{Name: "Done"}, nil, &foreign)
+
+	var task TaskResponse
+	doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Ship", Date: "2024-01-01", Status: "To Do"}, nil, &task)
+	taskPath := "/api/v1/tasks/" + strconv.Itoa(task.ID)
+
+	var patched TaskResponse
+	resp := doJSON(t, srv, http.MethodPatch, taskPath, map[string]int{"column_id": done.ID}, nil, &patched)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch column_id: status %d", resp.StatusCode)
+	}
+	if patched.Column_id != done.ID || patched.Status != "Done" {
+		t.Fatalf("patched task = %+v", patched)
+	}
+
+	resp = doJSON(t, srv, http.MethodPatch, taskPath, map[string]string{"status": "To Do"}, nil, &patched)
+	if resp.StatusCode != http.StatusOK || patched.Column_id != todo.ID {
+		t.Fatalf("patch status: status %d, task %+v", resp.StatusCode, patched)
+	}
+
+	for name, body := range map[string]interface{}{
+		"column of another project": map[string]int{"column_id": foreign.ID},
+		"missing column":            map[string]int{"column_id": 999},
+		"not an id":                 map[string]float64{"column_id": 1.5},
+		"status of another column":  map[string]interface{}{"column_id": done.ID, "status": "Review"},
+	} {
+		resp = doJSON(t, srv, http.MethodPatch, taskPath, body, nil, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, resp.StatusCode)
+		}
+	}
+}
+
+// Файлы задач, удалённых вместе с колонкой, видны в trash, и used сходится
+// с суммой по задачам и корзине
+func TestProjectStorageCountsTrash(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	ctx := context.Background()
+
+	columns, err := c.ListColumns(ctx, project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range []string{"To Do", "Done"} {
+		task, err := c.CreateTask(ctx, project.ID, api.Task{Name: status, Date: "2024-01-01", Status: status, Creator_id: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader(strings.Repeat("x", 10*(i+1)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DeleteColumn(ctx, project.ID, columns[0].ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := c.ProjectStorage(ctx, project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.Trash.Size != 10 || storage.Trash.Files != 1 {
+		t.Errorf("trash = %+v, want 10 bytes in 1 file", storage.Trash)
+	}
+	used, files := storage.Trash.Size, storage.Trash.Files
+	for _, task := range storage.ByTask {
+		used += task.Size
+		files += task.Files
+	}
+	if used != storage.Used || files != storage.Files || storage.Used != 30 {
+		t.Errorf("used %d in %d files, by_task and trash add up to %d in %d files", storage.Used, storage.Files, used, files)
+	}
+	var byType int64
+	for _, item := range storage.ByType {
+		byType += item.Size
+	}
+	if byType != storage.Used {
+		t.Errorf("by_type adds up to %d, used %d", byType, storage.Used)
+	}
+}
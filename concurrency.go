@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// Проверяет заголовок If-Match. Пустой заголовок или * пропускают любую версию.
+// If-Match сравнивает теги строго (RFC 9110), поэтому слабый тег W/"3" не
+// совпадает ни с какой версией.
+func ifMatchAllows(header string, version int) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Строка с колонкой version, которую меняет запрос
+type versionedRow struct {
+	table string
+	where string
+	args  []interface{}
+	// Текущее состояние ресурса для ответа 412
+	current func(db *sqlx.DB) (interface{}, error)
+}
+
+// Ошибка из update, о которой updateVersioned отвечает клиенту с заданным статусом
+type updateError struct {
+	status  int
+	message string
+}
+
+func (e *updateError) Error() string {
+	return e.message
+}
+
+// Блокирует строку, проверяет If-Match, выполняет update и увеличивает версию.
+// При ошибке сам отвечает клиенту и возвращает false.
+func updateVersioned(c *gin.Context, db *sqlx.DB, row versionedRow, update func(tx *sqlx.Tx) error) bool {
+	tx, err := db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.Get(&version, fmt.Sprintf("SELECT version FROM %s WHERE %s%s", row.table, row.where, forUpdate(tx)), row.args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return false
+	}
+
+	if !ifMatchAllows(c.GetHeader("If-Match"), version) {
+		tx.Rollback()
+		respondPreconditionFailed(c, db, row.current)
+		return false
+	}
+
+	if err := update(tx); err != nil {
+		if updateErr, ok := err.(*updateError); ok {
+			c.JSON(updateErr.status, gin.H{"error": updateErr.message})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return false
+	}
+
+	err = tx.Get(&version, fmt.Sprintf("UPDATE %s SET version = version + 1 WHERE %s RETURNING version", row.table, row.where), row.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return false
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	c.Header("ETag", etag(version))
+	return true
+}
+
+// 412 с текущим состоянием ресурса, чтобы клиент мог разрешить конфликт
+func respondPreconditionFailed(c *gin.Context, db *sqlx.DB, current func(db *sqlx.DB) (interface{}, error)) {
+	state, err := current(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Resource was modified by someone else", "current": state})
+}
+
+func projectRow(id string) versionedRow {
+	return versionedRow{
+		table: "projects",
+		where: "id = $1",
+		args:  []interface{}{id},
+		current: func(db *sqlx.DB) (interface{}, error) {
+			return getProject(db, id)
+		},
+	}
+}
+
+func grantRow(projectID string, grantID string) versionedRow {
+	return versionedRow{
+		table: "grants",
+		where: "id = $1 AND project_id = $2",
+		args:  []interface{}{grantID, projectID},
+		current: func(db *sqlx.DB) (interface{}, error) {
+			return getGrant(db, projectID, grantID)
+		},
+	}
+}
+
+func getProject(db *sqlx.DB, id string) (Project, error) {
+	var project Project
+	err := db.Get(&project, "SELECT id, name, version FROM projects WHERE id = $1", id)
+	return project, err
+}
+
+func getGrant(db *sqlx.DB, projectID string, grantID string) (Grant, error) {
+	var grant Grant
+	err := db.Get(&grant, "SELECT id, name, descr, num, project_id, version FROM grants WHERE id = $1 AND project_id = $2", grantID, projectID)
+	return grant, err
+}
+
+// GET /api/v1/projects/:id
+func projectHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		project, err := getProject(db, c.Param("id"))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("ETag", etag(project.Version))
+		c.JSON(http.StatusOK, project)
+	})
+}
+
+// GET /api/v1/projects/:id/grants/:grantId
+func grantHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		grant, err := getGrant(db, c.Param("id"), c.Param("grantId"))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Grant not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("ETag", etag(grant.Version))
+		c.JSON(http.StatusOK, grant)
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestIfMatchAllows(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, true},
+		{`*`, true},
+		{`"3"`, true},
+		{`"2", "3"`, true},
+		{`"2"`, false},
+		{`W/"3"`, false},
+		{`W/"2", W/"3"`, false},
+		{`3`, false},
+	}
+	for _, tt := range tests {
+		if got := ifMatchAllows(tt.header, 3); got != tt.want {
+			t.Errorf("ifMatchAllows(%q, 3) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
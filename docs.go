@@ -0,0 +1,91 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>JustOnTime API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({ url: "/docs/openapi.json", dom_id: "#swagger-ui" });
+	</script>
+</body>
+</html>`
+
+// /docs
+func docsHandler() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+	})
+}
+
+// /docs/openapi.json
+func openAPIHandler() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openAPISpec)
+	})
+}
+
+var ginParamRe = regexp.MustCompile(`[:*]([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Переводит путь gin (/tasks/:id) в путь OpenAPI (/tasks/{id})
+func openAPIPath(ginPath string) string {
+	return ginParamRe.ReplaceAllString(ginPath, "{$1}")
+}
+
+// Сравнивает зарегистрированные маршруты со спецификацией и возвращает расхождения
+func checkOpenAPIRoutes(spec []byte, routes gin.RoutesInfo) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	var problems []string
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		key := route.Method + " " + openAPIPath(route.Path)
+		registered[key] = true
+		if !documented[key] {
+			problems = append(problems, fmt.Sprintf("route %s is not documented", key))
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, fmt.Sprintf("documented route %s is not registered", key))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems, nil
+}
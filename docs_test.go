@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := setupRouter(nil, objectStores{files: newMemoryStore(), avatars: newMemoryStore()}, uploadPolicy{})
+
+	problems, err := checkOpenAPIRoutes(openAPISpec, r.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
+
+func TestCheckOpenAPIRoutes(t *testing.T) {
+	spec := []byte(`{"paths": {"/tasks/{id}": {"parameters": [], "get": {}}, "/gone": {"delete": {}}}}`)
+	routes := gin.RoutesInfo{
+		{Method: "GET", Path: "/tasks/:id"},
+		{Method: "POST", Path: "/tasks"},
+	}
+
+	problems, err := checkOpenAPIRoutes(spec, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"documented route DELETE /gone is not registered",
+		"route POST /tasks is not documented",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %q, want %q", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Errorf("problems[%d] = %q, want %q", i, problems[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Сколько хранится ответ на запрос с Idempotency-Key
+const idempotencyTTL = 24 * time.Hour
+
+// Предел тела запроса с Idempotency-Key, которое читается в память. Загрузки
+// файлов идут через idempotentUpload.
+const idempotencyMaxBody = 1 << 20
+
+type idempotencyRecord struct {
+	RequestHash string `db:"request_hash"`
+	Status      int    `db:"status"`
+	Location    string `db:"location"`
+	Body        []byte `db:"body"`
+}
+
+// Запоминает тело ответа, чтобы сохранить его для повторов
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Повтор запроса с тем же Idempotency-Key возвращает сохранённый ответ
+// вместо повторного создания ресурса
+func idempotent(db *sqlx.DB) gin.HandlerFunc {
+	return idempotentRequest(db, bufferBody)
+}
+
+// То же для загрузки файла в задачу :id: тело ограничено пределом размера
+// файла задачи и по пути к обработчику лежит во временном файле, а не в памяти
+func idempotentUpload(db *sqlx.DB, uploads uploadPolicy) gin.HandlerFunc {
+	return idempotentRequest(db, func(c *gin.Context) (string, func(), bool) {
+		limit, err := taskUploadLimit(db, uploads, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return "", nil, false
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return "", nil, false
+		}
+		return spoolBody(c, limit+multipartOverhead, func() { respondFileTooLarge(c, limit) })
+	})
+}
+
+// Читает тело запроса в память и возвращает его хеш
+func bufferBody(c *gin.Context) (string, func(), bool) {
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, idempotencyMaxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			return "", nil, false
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(payload))
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), func() {}, true
+}
+
+// Копирует тело запроса не больше limit байт во временный файл, считая хеш
+// по пути. Файл удаляет возвращённая функция.
+func spoolBody(c *gin.Context, limit int64, tooLarge func()) (string, func(), bool) {
+	spool, err := os.CreateTemp("", "idempotent-*")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return "", nil, false
+	}
+	release := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(spool, hash), http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			tooLarge()
+			c.Abort()
+			return "", nil, false
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+	c.Request.Body = spool
+	return hex.EncodeToString(hash.Sum(nil)), release, true
+}
+
+// Общая часть idempotent и idempotentUpload. readBody читает тело, подменяет
+// c.Request.Body копией и возвращает хеш тела и функцию, освобождающую копию;
+// при ошибке он сам отвечает клиенту и возвращает false.
+func idempotentRequest(db *sqlx.DB, readBody func(c *gin.Context) (string, func(), bool)) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		route := c.Request.Method + " " + c.Request.URL.Path
+		// Ключи разных пользователей не пересекаются, иначе один получил бы
+		// сохранённый ответ другого
+		caller := c.GetHeader("X-User-ID")
+
+		requestHash, release, ok := readBody(c)
+		if !ok {
+			return
+		}
+		defer release()
+
+		_, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < $1", time.Now().UTC().Add(-idempotencyTTL))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := db.Exec("INSERT INTO idempotency_keys (key, route, caller, request_hash) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING", key, route, caller, requestHash)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			var record idempotencyRecord
+			err := db.Get(&record, "SELECT request_hash, status, location, body FROM idempotency_keys WHERE key = $1 AND route = $2 AND caller = $3", key, route, caller)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is in progress"})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if record.RequestHash != requestHash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+				return
+			}
+			if record.Status == 0 {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is in progress"})
+				return
+			}
+
+			if record.Location != "" {
+				c.Header("Location", record.Location)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.Status, "application/json; charset=utf-8", record.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		completed := false
+		defer func() {
+			// Обработчик упал или не ответил: освобождаем ключ, иначе повтор
+			// получал бы 409 до истечения idempotencyTTL
+			if !completed {
+				db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND route = $2 AND caller = $3", key, route, caller)
+			}
+		}()
+		c.Next()
+
+		status := writer.Status()
+		if !writer.Written() || status >= http.StatusInternalServerError {
+			// Ошибку сервера не запоминаем, клиент может повторить запрос
+			return
+		}
+		completed = true
+
+		_, err = db.Exec("UPDATE idempotency_keys SET status = $1, location = $2, body = $3 WHERE key = $4 AND route = $5 AND caller = $6",
+			status, writer.Header().Get("Location"), writer.body.Bytes(), key, route, caller)
+		if err != nil {
+			fmt.Println("error: ", err.Error())
+		}
+	})
+}
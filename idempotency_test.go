@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestIdempotencyKeyPerCaller(t *testing.T) {
+	srv, db := newTestServer(t)
+
+	var project Project
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board"}, nil, &project)
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+	doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "To Do"}, nil, nil)
+
+	create := func(user string) (TaskResponse, *http.Response) {
+		var task TaskResponse
+		resp := doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Once", Date: "2024-01-01", Status: "To Do"}, map[string]string{"Idempotency-Key": "same-key", "X-User-ID": user}, &task)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create as user %s: status %d", user, resp.StatusCode)
+		}
+		return task, resp
+	}
+
+	first, _ := create("1")
+	replayed, resp := create("1")
+	if resp.Header.Get("Idempotent-Replayed") != "true" || replayed.ID != first.ID {
+		t.Errorf("repeat by the same user created task %d, want replay of %d", replayed.ID, first.ID)
+	}
+
+	other, resp := create("2")
+	if resp.Header.Get("Idempotent-Replayed") != "" || other.ID == first.ID {
+		t.Errorf("another user with the same key got task %d of the first user", other.ID)
+	}
+
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d tasks created, want 2", count)
+	}
+}
@@ -2,130 +2,88 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 
-	"encoding/base64"
-	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
+	"justintime-backend/api"
 )
 
-type User struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Role     string `json:"role"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	Avatar   []byte `json:"avatar"`
-	Status   string `json:"status"`
-}
-
-type Project struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Tasks []Task `json:"tasks"`
-}
-
-type Task struct {
-	ID         int            `json:"id"`
-	Name       string         `json:"name"`
-	Descr      sql.NullString `json:"descr"`
-	Date       string         `json:"date"`
-	Date_act   sql.NullString `json:"date_act"`
-	Empl_id    sql.NullString `json:"empl_id"`
-	Avatar     []byte         `json:"avatar"`
-	Project_id int            `json:"projectId"`
-	Status     string         `json:"status"`
-	Priority   sql.NullString `json:"priority"`
-	Creator_id int            `json:"creator_id"`
-}
-
-type Grant struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	Descr      string `json:"descr"`
-	Num        int    `json:"num"`
-	Project_id int    `json:"projectId"`
-}
-
-type TaskResponse struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	Descr      string `json:"descr"`
-	Date       string `json:"date"`
-	Date_act   string `json:"date_act"`
-	Empl_id    string `json:"empl_id"`
-	Avatar     []byte `json:"avatar"`
-	Project_id int    `json:"projectId"`
-	Status     string `json:"status"`
-	Priority   string `json:"priority"`
-	Creator_id int    `json:"creator_id"`
-	Files      []File `json:"files"`
-}
-
-type File struct {
-	ID       int    `json:"id"`
-	TaskID   int    `json:"task_id"`
-	Name     string `json:"name"`
-	FileUuid string `json:"file_uuid"`
-}
+// Типы запросов и ответов общие с клиентом, см. пакет api
+type (
+	User             = api.User
+	UserPatch        = api.UserPatch
+	AvatarData       = api.AvatarData
+	Project          = api.Project
+	NewProject       = api.NewProject
+	Column           = api.Column
+	ColumnUpdate     = api.ColumnUpdate
+	ColumnMove       = api.ColumnMove
+	ColumnOrder      = api.ColumnOrder
+	ColumnTrash      = api.ColumnTrash
+	ColumnRestore    = api.ColumnRestore
+	Task             = api.Task
+	TaskResponse     = api.TaskResponse
+	TaskInfo         = api.TaskInfo
+	TaskPriority     = api.TaskPriority
+	TaskChange       = api.TaskChange
+	TaskHistoryEntry = api.TaskHistoryEntry
+	Grant            = api.Grant
+	File             = api.File
+	NewDirectUpload  = api.NewDirectUpload
+	DirectUpload     = api.DirectUpload
+	ProjectStorage   = api.ProjectStorage
+	TaskStorage      = api.TaskStorage
+	TypeStorage      = api.TypeStorage
+	TrashStorage     = api.TrashStorage
+	UserStorage      = api.UserStorage
+	Notification     = api.Notification
+)
 
 func main() {
-	// Get environment variables
-	databaseHost := os.Getenv("DATABASE_HOST")
-	databaseUser := os.Getenv("DATABASE_USER")
-	databasePassword := os.Getenv("DATABASE_PASSWORD")
-	databasePort := "5432"
-	databaseName := os.Getenv("DATABASE_NAME")
-
-	// databaseHost := "localhost"
-	// databaseUser := "postgres"
-	// databasePort := "5433"
-	// databasePassword := "0921"
-	// databaseName := "postgres"
-
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", databaseHost, databasePort, databaseUser, databasePassword, databaseName)
-
-	// Create a new router
-	db, err := sqlx.Connect("postgres", dsn)
-	if err != nil {
-		log.Fatal(err)
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
 	}
-	defer db.Close()
-
-	// gin.SetMode(gin.ReleaseMode)
+}
 
+func setupRouter(db *sqlx.DB, stores objectStores, uploads uploadPolicy) *gin.Engine {
 	r := gin.Default()
 
 	r.GET("/", startPageHandler())
 
+	// Документация API
+	r.GET("/docs", docsHandler())
+	r.GET("/docs/openapi.json", openAPIHandler())
+
+	// Версионированное API
+	registerV1Routes(r, db, stores, uploads)
+
+	// Аватары по ссылкам из ответов API
+	r.GET("/avatars/:userId", avatarHandler(db, stores.avatars))
+
+	// Старые маршруты, оставлены для совместимости
 	// Группировка маршрутов для регистрации и логина
-	authRoutes := r.Group("/auth")
+	authRoutes := r.Group("/auth", deprecatedRoutes())
 	{
 		authRoutes.POST("/login", loginHandler(db))
-		authRoutes.POST("/register", registerHandler(db))
+		authRoutes.POST("/register", idempotent(db), registerHandler(db, stores.avatars))
 		authRoutes.GET("/register/check/:login", checkLoginHandler(db))
 	}
 
 	// Группировка маршрутов для проектов
-	projectRoutes := r.Group("/projects")
+	projectRoutes := r.Group("/projects", deprecatedRoutes())
 	{
 		projectRoutes.GET("/", projectsHandler(db))
 		projectRoutes.GET("/:id/tasks", projectTasksHandler(db))
 		projectRoutes.DELETE("/:id", projectDeleteHandler(db))
-		projectRoutes.POST("/new", projectNewHandler(db))
+		projectRoutes.POST("/new", idempotent(db), projectNewHandler(db))
 		projectRoutes.POST("/:id/column", projectNewColumnHandler(db))
 		projectRoutes.DELETE("/:id/column", projectDeleteColumnHandler(db))
 		projectRoutes.POST("/:id/column/update", projectUpdateColumnHandler(db))
@@ -134,38 +92,37 @@ func main() {
 		projectRoutes.DELETE("/:id/removeUser", projectDeleteUserHandler(db))
 		projectRoutes.POST("/:id/rename", projectRenameHandler(db))
 		projectRoutes.GET("/:id/grants", projectGrantsHandler(db))
-		projectRoutes.POST("/:id/addGrant", projectAddGrantHandler(db))
+		projectRoutes.POST("/:id/addGrant", idempotent(db), projectAddGrantHandler(db))
 		projectRoutes.DELETE("/:id/removeGrant", projectDeleteGrantHandler(db))
 		projectRoutes.POST("/:id/editGrant", projectEditGrantHandler(db))
 		projectRoutes.GET("/:id/usersOnline", projectUsersOnlineHandler(db))
 	}
 
 	// Группировка маршрутов для задач
-	taskRoutes := r.Group("/tasks")
+	taskRoutes := r.Group("/tasks", deprecatedRoutes())
 	{
 		taskRoutes.GET("/:id", tasksHandler(db))
 		taskRoutes.DELETE("/:id", taskDeleteHandler(db))
 		taskRoutes.POST("/:id/updateStatus", taskStatusUpdateHandler(db))
 		taskRoutes.POST("/:id/assign/", taskAssignHandler(db))
-		taskRoutes.POST("/new", taskNewHandler(db))
+		taskRoutes.POST("/new", idempotent(db), taskNewHandler(db))
 		taskRoutes.POST("/:id/updateInfo", taskInfoUpdateHandler(db))
 		taskRoutes.POST("/:id/updatePriority", taskPriorityUpdateHandler(db))
-		taskRoutes.POST("/:id/addFile", taskAddFileHandler(db))
+		taskRoutes.POST("/:id/addFile", idempotentUpload(db, uploads), taskAddFileHandler(db, stores.files, uploads))
 	}
 
 	// Профиль пользователя
-	profileRoutes := r.Group("/profile")
+	profileRoutes := r.Group("/profile", deprecatedRoutes())
 	{
 		profileRoutes.GET("/:id", profileHandler(db))
-		profileRoutes.POST("/:id/updateAvatar", profileUpdateAvatarHandler(db))
+		profileRoutes.POST("/:id/updateAvatar", profileUpdateAvatarHandler(db, stores.avatars))
 		profileRoutes.POST("/:id/addProject", profileAddProjectHandler(db))
 		profileRoutes.GET("/:id/projects", profileProjectsHandler(db))
 		profileRoutes.DELETE("/:id", profileRemoveProjectHandler(db))
 		profileRoutes.POST("/:id/updateOnlineStatus", profileUpdateOnlineStatusHandler(db))
-		// uploadImageHandler())
 	}
 
-	r.Run()
+	return r
 }
 
 func startPageHandler() gin.HandlerFunc {
@@ -183,9 +140,10 @@ func loginHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		row := db.QueryRow("SELECT id, name, role, avatar, status FROM users WHERE login = $1 AND password = $2", user.Login, user.Password)
+		row := db.QueryRow("SELECT id, name, role, COALESCE(avatar_key, ''), status, disabled FROM users WHERE login = $1 AND password = $2", user.Login, user.Password)
 
-		err := row.Scan(&user.ID, &user.Name, &user.Role, &user.Avatar, &user.Status)
+		var disabled bool
+		err := row.Scan(&user.ID, &user.Name, &user.Role, &user.Avatar, &user.Status, &disabled)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid login or password"})
@@ -194,8 +152,12 @@ func loginHandler(db *sqlx.DB) gin.HandlerFunc {
 			}
 			return
 		}
+		if disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User is disabled"})
+			return
+		}
 
-		user.Avatar = []byte(base64.StdEncoding.EncodeToString(user.Avatar))
+		user.Avatar = avatarURL(user.ID, user.Avatar)
 
 		rows, err := db.Query("SELECT project_id FROM user_projects WHERE user_id = $1", user.ID)
 		if err != nil {
@@ -219,7 +181,7 @@ func loginHandler(db *sqlx.DB) gin.HandlerFunc {
 }
 
 // /register
-func registerHandler(db *sqlx.DB) gin.HandlerFunc {
+func registerHandler(db *sqlx.DB, avatars ObjectStore) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		var user User
 		if err := c.BindJSON(&user); err != nil {
@@ -227,16 +189,26 @@ func registerHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("INSERT INTO users (name, role, login, password, status) VALUES ($1, $2, $3, $4, $5)", user.Name, user.Role, user.Login, user.Password, user.Status)
+		user, err := insertUser(db, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		createDefaultAvatar(c.Request.Context(), db, avatars, user)
 
-		c.JSON(http.StatusOK, gin.H{"message": "User registered"})
+		c.JSON(http.StatusOK, gin.H{"message": "User registered", "user": user})
 	})
 }
 
+// Создаёт пользователя и возвращает его без пароля
+func insertUser(db sqlx.Ext, user User) (User, error) {
+	var created User
+	err := sqlx.Get(db, &created, "INSERT INTO users (name, role, login, password, status) VALUES ($1, $2, $3, $4, $5) RETURNING id, name, role, login, status",
+		user.Name, user.Role, user.Login, user.Password, user.Status)
+	created.Avatar = avatarURL(created.ID, "")
+	return created, err
+}
+
 // /register/check/:login
 func checkLoginHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -295,21 +267,38 @@ func nullStringToString(ns sql.NullString) string {
 	return ""
 }
 
+func newTaskResponse(task Task) TaskResponse {
+	return TaskResponse{
+		ID:         task.ID,
+		Name:       task.Name,
+		Descr:      nullStringToString(task.Descr),
+		Date:       task.Date,
+		Date_act:   nullStringToString(task.Date_act),
+		Empl_id:    nullStringToString(task.Empl_id),
+		Avatar:     assigneeAvatarURL(task),
+		Project_id: task.Project_id,
+		Status:     task.Status,
+		Priority:   nullStringToString(task.Priority),
+		Creator_id: task.Creator_id,
+		Version:    task.Version,
+		Column_id:  task.Column_id,
+	}
+}
+
 // /projects/:id/tasks
 func projectTasksHandler(db *sqlx.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		projectID := c.Param("id")
 
 		var tasks []Task
-		err := db.Select(&tasks, `SELECT tasks.id, tasks.name, tasks.descr, tasks.date, tasks.date_act, tasks.empl_id, users.avatar, tasks.project_id, tasks.status, tasks.priority, tasks.creator_id from tasks left join users on tasks.empl_id = users.id WHERE project_id = $1`, projectID)
+		err := db.Select(&tasks, `SELECT tasks.id, tasks.name, tasks.descr, tasks.date, tasks.date_act, tasks.empl_id, COALESCE(users.avatar_key, '') AS avatar, tasks.project_id, tasks.status, tasks.priority, tasks.creator_id, tasks.version, COALESCE(tasks.column_id, 0) AS column_id from tasks left join users on tasks.empl_id = users.id WHERE project_id = $1`, projectID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
 			return
 		}
 
-		var columns []string
-		err = db.Select(&columns, "SELECT columns_ FROM projects WHERE id = $1", projectID)
+		columns, err := projectColumns(db, projectID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
@@ -318,20 +307,7 @@ func projectTasksHandler(db *sqlx.DB) gin.HandlerFunc {
 
 		var tasksResponse []TaskResponse
 		for _, task := range tasks {
-			taskResponse := TaskResponse{
-				ID:         task.ID,
-				Name:       task.Name,
-				Descr:      nullStringToString(task.Descr),
-				Date:       task.Date,
-				Date_act:   nullStringToString(task.Date_act),
-				Empl_id:    nullStringToString(task.Empl_id),
-				Avatar:     task.Avatar,
-				Project_id: task.Project_id,
-				Status:     task.Status,
-				Priority:   nullStringToString(task.Priority),
-				Creator_id: task.Creator_id,
-			}
-			tasksResponse = append(tasksResponse, taskResponse)
+			tasksResponse = append(tasksResponse, newTaskResponse(task))
 		}
 
 		c.JSON(http.StatusOK, gin.H{"columns": columns, "tasks": tasksResponse})
@@ -343,20 +319,7 @@ func projectDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		id := c.Param("id")
 
-		_, err := db.Exec("DELETE FROM projects WHERE id = $1", id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		_, err = db.Exec("DELETE FROM tasks WHERE project_id = $1", id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		_, err = db.Exec("DELETE FROM user_projects WHERE project_id = $1", id)
-		if err != nil {
+		if err := deleteProject(db, id); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -365,9 +328,33 @@ func projectDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
 	})
 }
 
-type NewProject struct {
-	Name   string   `json:"name"`
-	Logins []string `json:"logins"`
+// Удаляет проект вместе с задачами, их файлами и участниками
+func deleteProject(db *sqlx.DB, id interface{}) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteTaskFiles(tx, "SELECT id FROM tasks WHERE project_id = $1", id); err != nil {
+		return err
+	}
+	if err := deleteTrashedTaskFiles(tx, id); err != nil {
+		return err
+	}
+
+	// Задачи раньше проекта: вместе с проектом удаляются колонки, на которые они ссылаются
+	for _, query := range []string{
+		"DELETE FROM tasks WHERE project_id = $1",
+		"DELETE FROM user_projects WHERE project_id = $1",
+		"DELETE FROM projects WHERE id = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // /projects/new
@@ -380,64 +367,63 @@ func projectNewHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		row := db.QueryRow("select * from projects where name = $1", project.Name)
-		var tempProject NewProject
-		err := row.Scan(&tempProject)
-		if err == sql.ErrNoRows {
-			// Проект с таким именем не найден, продолжить создание
-		} else if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Project with this name already exists"})
-			return
-		}
-
-		_, err = db.Exec("INSERT INTO projects (name) VALUES ($1)", project.Name)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		}
-
-		var projectID int
-		err = db.Get(&projectID, "SELECT id FROM projects WHERE name = $1", project.Name)
+		created, err := insertProject(db, project)
 		if err != nil {
+			if err == errProjectExists {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
 			return
 		}
 
-		for _, login := range project.Logins {
-			var userID int
-			err = db.Get(&userID, "SELECT id FROM users WHERE login = $1", login)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					// Если пользователь не найден, пропустить этот логин и перейти к следующему
-					continue
-				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					fmt.Println("error: ", err.Error())
-					return
-				}
-			}
+		c.JSON(http.StatusOK, gin.H{"message": "Project " + project.Name + " added", "project": created})
+	})
+}
 
-			_, err = db.Exec("INSERT INTO user_projects (user_id, project_id) VALUES ($1, $2)", userID, projectID)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				fmt.Println("error: ", err.Error())
-				return
-			}
+var errProjectExists = errors.New("Project with this name already exists")
 
-		}
+// Создаёт проект и добавляет в него пользователей по логинам.
+// Неизвестные логины пропускаются.
+func insertProject(db *sqlx.DB, project NewProject) (Project, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return Project{}, err
+	}
+	defer tx.Rollback()
 
-		c.JSON(http.StatusOK, gin.H{"message": "Project " + project.Name + " added"})
-	})
+	created, err := createProject(tx, project)
+	if err != nil {
+		return Project{}, err
+	}
+	return created, tx.Commit()
 }
 
-type Column struct {
-	Name string `json:"name"`
+// То же внутри транзакции вызывающего
+func createProject(tx *sqlx.Tx, project NewProject) (Project, error) {
+	var count int
+	err := tx.Get(&count, "SELECT COUNT(*) FROM projects WHERE name = $1", project.Name)
+	if err != nil {
+		return Project{}, err
+	}
+	if count > 0 {
+		return Project{}, errProjectExists
+	}
+
+	var created Project
+	err = tx.Get(&created, "INSERT INTO projects (name) VALUES ($1) RETURNING id, name, version", project.Name)
+	if err != nil {
+		return Project{}, err
+	}
+
+	for _, login := range project.Logins {
+		_, err = tx.Exec("INSERT INTO user_projects (user_id, project_id) SELECT id, $1 FROM users WHERE login = $2 ON CONFLICT DO NOTHING", created.ID, login)
+		if err != nil {
+			return Project{}, err
+		}
+	}
+	return created, nil
 }
 
 // create new column /projects/:id/column
@@ -452,10 +438,11 @@ func projectNewColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE projects SET columns_ = array_append(columns_, $1) WHERE id = $2", column.Name, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			_, err := appendColumn(tx, id, column)
+			return err
+		})
+		if !ok {
 			return
 		}
 
@@ -463,7 +450,7 @@ func projectNewColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 	})
 }
 
-// delete column /projects/:id/column
+// delete column /projects/:id/column?target=Done или ?cascade=true
 func projectDeleteColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		id := c.Param("id")
@@ -474,29 +461,26 @@ func projectDeleteColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE projects SET columns_ = array_remove(columns_, $1) WHERE id = $2", column.Name, id)
+		cascade, err := strconv.ParseBool(c.DefaultQuery("cascade", "false"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cascade must be true or false"})
 			return
 		}
 
-		_, err = db.Exec("DELETE FROM tasks WHERE project_id = $1 AND status = $2", id, column.Name)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+		var trashed ColumnTrash
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			var err error
+			trashed, err = trashColumn(tx, id, columnByName(column.Name), columnByName(c.Query("target")), cascade)
+			return err
+		})
+		if !ok {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Column + " + column.Name + " deleted"})
+		c.JSON(http.StatusOK, gin.H{"message": "Column + " + column.Name + " deleted", "tasks": trashed.Tasks, "trash_id": trashed.ID})
 	})
 }
 
-type ColumnUpdate struct {
-	Old_name string `json:"old_name"`
-	New_name string `json:"new_name"`
-}
-
 // update name of column /projects/:id/column/update
 func projectUpdateColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -509,17 +493,11 @@ func projectUpdateColumnHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE projects SET columns_ = array_replace(columns_, $1, $2) WHERE id = $3", columnUpdate.Old_name, columnUpdate.New_name, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		}
-
-		_, err = db.Exec("UPDATE tasks SET status = $1 WHERE project_id = $2 AND status = $3", columnUpdate.New_name, id, columnUpdate.Old_name)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			_, err := updateColumn(tx, id, columnByName(columnUpdate.Old_name), Column{Name: columnUpdate.New_name})
+			return err
+		})
+		if !ok {
 			return
 		}
 
@@ -533,11 +511,12 @@ func projectUsersHandler(db *sqlx.DB) gin.HandlerFunc {
 		id := c.Param("id")
 
 		var users []User
-		err := db.Select(&users, `SELECT users.id, users.name, users.role, users.avatar FROM users left join user_projects on users.id = user_projects.user_id WHERE user_projects.project_id = $1`, id)
+		err := db.Select(&users, `SELECT users.id, users.name, users.role, COALESCE(users.avatar_key, '') AS avatar FROM users left join user_projects on users.id = user_projects.user_id WHERE user_projects.project_id = $1`, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		setAvatarURLs(users)
 
 		c.JSON(http.StatusOK, gin.H{"users": users})
 	})
@@ -568,11 +547,9 @@ func projectAddUserHandler(db *sqlx.DB) gin.HandlerFunc {
 
 		_, err = db.Exec("INSERT INTO user_projects (user_id, project_id) VALUES ($1, $2)", userID, projectId)
 		if err != nil {
-			if pqErr, ok := err.(*pq.Error); ok {
-				if pqErr.Code == "23505" {
-					c.JSON(http.StatusBadRequest, gin.H{"error": "User already in project"})
-					return
-				}
+			if isUniqueViolation(err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "User already in project"})
+				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -631,9 +608,11 @@ func projectRenameHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE projects SET name = $1 WHERE id = $2", project.Name, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ok := updateVersioned(c, db, projectRow(id), func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("UPDATE projects SET name = $1 WHERE id = $2", project.Name, id)
+			return err
+		})
+		if !ok {
 			return
 		}
 
@@ -669,17 +648,24 @@ func projectAddGrantHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("INSERT INTO grants (name, descr, num, project_id) VALUES ($1, $2, $3, $4)", grant.Name, grant.Descr, grant.Num, id)
+		grant, err := insertGrant(db, id, grant)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Grant added"})
+		c.JSON(http.StatusOK, gin.H{"message": "Grant added", "grant": grant})
 	})
 }
 
+func insertGrant(db sqlx.Ext, projectID string, grant Grant) (Grant, error) {
+	var created Grant
+	err := sqlx.Get(db, &created, "INSERT INTO grants (name, descr, num, project_id) VALUES ($1, $2, $3, $4) RETURNING id, name, descr, num, project_id, version",
+		grant.Name, grant.Descr, grant.Num, projectID)
+	return created, err
+}
+
 // /projects/:id/removeGrant
 func projectDeleteGrantHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -715,10 +701,11 @@ func projectEditGrantHandler(db *sqlx.DB) gin.HandlerFunc {
 
 		fmt.Println(grant.Num)
 
-		_, err := db.Exec("UPDATE grants SET descr = $1, num = $2, name = $3 WHERE id = $4 AND project_id = $5", grant.Descr, grant.Num, grant.Name, grant.ID, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+		ok := updateVersioned(c, db, grantRow(id, grant.ID), func(tx *sqlx.Tx) error {
+			_, err := tx.Exec("UPDATE grants SET descr = $1, num = $2, name = $3 WHERE id = $4 AND project_id = $5", grant.Descr, grant.Num, grant.Name, grant.ID, id)
+			return err
+		})
+		if !ok {
 			return
 		}
 
@@ -732,11 +719,12 @@ func projectUsersOnlineHandler(db *sqlx.DB) gin.HandlerFunc {
 		id := c.Param("id")
 
 		var users []User
-		err := db.Select(&users, `SELECT users.id, users.name, users.avatar FROM users left join user_projects on users.id = user_projects.user_id WHERE user_projects.project_id = $1 and users.status = 'online'`, id)
+		err := db.Select(&users, `SELECT users.id, users.name, COALESCE(users.avatar_key, '') AS avatar FROM users left join user_projects on users.id = user_projects.user_id WHERE user_projects.project_id = $1 and users.status = 'online'`, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		setAvatarURLs(users)
 
 		c.JSON(http.StatusOK, gin.H{"users": users})
 	})
@@ -748,26 +736,22 @@ func tasksHandler(db *sqlx.DB) gin.HandlerFunc {
 		id := c.Param("id")
 
 		var task Task
-		err := db.Get(&task, "SELECT * FROM tasks WHERE id = $1", id)
+		err := db.Get(&task, "SELECT "+taskFields+" FROM tasks WHERE id = $1", id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
 			return
 		}
 
-		var taskResponse TaskResponse
-		taskResponse.ID = task.ID
-		taskResponse.Name = task.Name
-		taskResponse.Descr = nullStringToString(task.Descr)
-		taskResponse.Date = task.Date
-		taskResponse.Date_act = nullStringToString(task.Date_act)
-		taskResponse.Empl_id = nullStringToString(task.Empl_id)
-		taskResponse.Project_id = task.Project_id
-		taskResponse.Status = task.Status
-		taskResponse.Priority = nullStringToString(task.Priority)
-		taskResponse.Creator_id = task.Creator_id
+		taskResponse := newTaskResponse(task)
+		c.Header("ETag", etag(task.Version))
 
-		rows, err := db.Query("SELECT id, name, object_name FROM files WHERE task_id = $1", id)
+		// Только последние версии файлов
+		rows, err := db.Query("SELECT id, name, object_name, content_type, size, scan_status, has_thumbnails, version, (SELECT COUNT(*) FROM files versions WHERE "+versionsOf("files")+") FROM files WHERE task_id = $1 AND NOT EXISTS (SELECT 1 FROM files versions WHERE "+versionsOf("files")+" AND versions.version > files.version)", id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			fmt.Println("error: ", err.Error())
@@ -778,12 +762,14 @@ func tasksHandler(db *sqlx.DB) gin.HandlerFunc {
 		var files []File
 		for rows.Next() {
 			var file File
-			if err := rows.Scan(&file.ID, &file.Name, &file.FileUuid); err != nil {
+			var hasThumbnails bool
+			if err := rows.Scan(&file.ID, &file.Name, &file.FileUuid, &file.ContentType, &file.Size, &file.ScanStatus, &hasThumbnails, &file.Version, &file.Versions); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				fmt.Println("error: ", err.Error())
 				return
 			}
 			file.TaskID, _ = strconv.Atoi(id)
+			file.Thumbnails = thumbnailURLs(file, hasThumbnails)
 			files = append(files, file)
 		}
 
@@ -798,7 +784,7 @@ func taskDeleteHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		id := c.Param("id")
 
-		_, err := db.Exec("DELETE FROM tasks WHERE id = $1", id)
+		err := deleteTask(db, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -819,9 +805,9 @@ func taskStatusUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE tasks SET status = $1 WHERE id = $2", task.Status, id)
+		_, err := updateTask(db, id, c.GetHeader("If-Match"), setTaskFields(map[string]*string{"status": &task.Status}))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondTaskPatchError(c, err)
 			return
 		}
 
@@ -835,9 +821,15 @@ func taskAssignHandler(db *sqlx.DB) gin.HandlerFunc {
 		id := c.Param("id")
 		empl_id := c.DefaultQuery("empl_id", "")
 
-		_, err := db.Exec("UPDATE tasks SET empl_id = $1 WHERE id = $2", empl_id, id)
+		// Пустой empl_id снимает исполнителя
+		var assignee *string
+		if empl_id != "" {
+			assignee = &empl_id
+		}
+
+		_, err := updateTask(db, id, c.GetHeader("If-Match"), setTaskFields(map[string]*string{"empl_id": assignee}))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondTaskPatchError(c, err)
 			return
 		}
 
@@ -861,21 +853,58 @@ func taskNewHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("INSERT INTO tasks (name, descr, date, date_act, empl_id, project_id, status, priority, creator_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
-			task.Name, task.Descr, task.Date, task.Date_act, task.Empl_id, task.Project_id, task.Status, task.Priority, task.Creator_id)
+		created, err := insertTask(db, task)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+			respondTaskPatchError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Task added"})
+		c.JSON(http.StatusOK, gin.H{"message": "Task added", "task": created})
 	})
 }
 
-type TaskInfo struct {
-	Name  string `json:"name"`
-	Descr string `json:"descr"`
+// Поля задачи для sqlx, column_id пуст у задач вне доски
+const taskFields = "id, name, descr, date, date_act, empl_id, project_id, status, priority, creator_id, version, COALESCE(column_id, 0) AS column_id"
+
+// Создаёт задачу в колонке task.Column_id или, если он не задан, в колонке
+// с именем task.Status. Несуществующая колонка — *taskPatchError.
+func insertTask(db sqlx.Ext, task Task) (TaskResponse, error) {
+	if err := validateTaskDate("date", task.Date); err != nil {
+		return TaskResponse{}, err
+	}
+	if task.Date_act.Valid {
+		if err := validateTaskDate("date_act", task.Date_act.String); err != nil {
+			return TaskResponse{}, err
+		}
+	}
+
+	var column Column
+	var err error
+	if task.Column_id != 0 {
+		err = sqlx.Get(db, &column, "SELECT "+columnFields+" FROM columns WHERE id = $1 AND project_id = $2", task.Column_id, task.Project_id)
+		if err == sql.ErrNoRows {
+			return TaskResponse{}, badTaskPatch("Column %d does not exist in the project", task.Column_id)
+		}
+	} else {
+		column, err = findColumn(db, task.Project_id, task.Status)
+		if err == sql.ErrNoRows {
+			return TaskResponse{}, badTaskPatch("Column %q does not exist in the project", task.Status)
+		}
+	}
+	if err != nil {
+		return TaskResponse{}, err
+	}
+
+	var created Task
+	err = sqlx.Get(db, &created, "INSERT INTO tasks (name, descr, date, date_act, empl_id, project_id, status, priority, creator_id, column_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING "+taskFields,
+		task.Name, task.Descr, task.Date, task.Date_act, task.Empl_id, task.Project_id, column.Name, task.Priority, task.Creator_id, column.ID)
+	if err != nil {
+		return TaskResponse{}, err
+	}
+
+	response := newTaskResponse(created)
+	response.Files = []File{}
+	return response, nil
 }
 
 // /tasks/:id/updateInfo
@@ -889,11 +918,9 @@ func taskInfoUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE tasks SET name = $1, descr = $2 WHERE id = $3",
-			task.Name, task.Descr, id)
+		_, err := updateTask(db, id, c.GetHeader("If-Match"), setTaskFields(map[string]*string{"name": &task.Name, "descr": &task.Descr}))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+			respondTaskPatchError(c, err)
 			return
 		}
 
@@ -901,10 +928,6 @@ func taskInfoUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 	})
 }
 
-type TaskPriority struct {
-	Priority string `json:"priority"`
-}
-
 // /tasks/:id/updatePriority
 func taskPriorityUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -917,10 +940,9 @@ func taskPriorityUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE tasks SET priority = $1 WHERE id = $2", priority.Priority, id)
+		_, err := updateTask(db, id, c.GetHeader("If-Match"), setTaskFields(map[string]*string{"priority": &priority.Priority}))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+			respondTaskPatchError(c, err)
 			return
 		}
 
@@ -929,57 +951,176 @@ func taskPriorityUpdateHandler(db *sqlx.DB) gin.HandlerFunc {
 }
 
 // /tasks/:id/addFile
-func taskAddFileHandler(db *sqlx.DB) gin.HandlerFunc {
+func taskAddFileHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		id := c.Param("id")
-
-		file, header, err := c.Request.FormFile("file")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
+		file, ok := addTaskFile(c, db, files, uploads)
+		if !ok {
 			return
 		}
-		defer file.Close()
 
-		accessKey := os.Getenv("ACCESS_KEY")
-		secretKey := os.Getenv("SECRET_KEY")
-		bucketName := os.Getenv("BUCKET_NAME_FILES")
-		regionName := os.Getenv("REGION_NAME")
-		fileName := header.Filename
-		fileExt := filepath.Ext(fileName)
-		objectName := uuid.New().String() + fileExt
+		c.JSON(http.StatusOK, gin.H{"message": "File added", "file": file})
+	})
+}
 
-		sess, err := session.NewSession(&aws.Config{
-			Region:      aws.String(regionName),
-			Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
-			Endpoint:    aws.String("https://storage.yandexcloud.net"),
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии: " + err.Error()})
-			return
+// Загружает файл из формы в бакет и прикрепляет его к задаче.
+// Размер и тип проверяются по uploads до загрузки в хранилище.
+// При ошибке сам отвечает клиенту и возвращает false.
+func addTaskFile(c *gin.Context, db *sqlx.DB, files ObjectStore, uploads uploadPolicy) (File, bool) {
+	id := c.Param("id")
+
+	limit, err := taskUploadLimit(db, uploads, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return File{}, false
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return File{}, false
+	}
 
-		uploader := s3manager.NewUploader(sess)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondFileTooLarge(c, limit)
+			return File{}, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return File{}, false
+	}
+	defer file.Close()
 
-		_, err = uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(objectName),
-			Body:   file,
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
+	if header.Size > limit {
+		respondFileTooLarge(c, limit)
+		return File{}, false
+	}
+
+	replaces, err := parseReplaces(c.Request.FormValue("replaces"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return File{}, false
+	}
+	if !checkReplaces(c, db, id, replaces) {
+		return File{}, false
+	}
+	if !checkQuota(c, db, uploads, id, requestUploader(c), header.Size) {
+		return File{}, false
+	}
+
+	_, detected, err := sniffUpload(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return File{}, false
+	}
+	if err := uploads.check(detected, header.Filename); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return File{}, false
+	}
+
+	sum, err := contentSHA256(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return File{}, false
+	}
+
+	taskID, _ := strconv.Atoi(id)
+	stored := File{TaskID: taskID, Name: header.Filename, ContentType: detected.String(), Size: header.Size, ScanStatus: uploads.initialScanStatus()}
+	// Расширение ключа берём из настоящего типа, а не из имени от клиента
+	key := blobKey(sum, detected.Extension())
+	created, err := insertBlobFile(db, uploads, stored, requestUploader(c), replaces, sum, key, func(key string) (bool, error) {
+		return storeBlob(c.Request.Context(), files, key, file, detected)
+	})
+	if err != nil {
+		if usage, ok := err.(*quotaError); ok {
+			respondQuotaExceeded(c, usage)
+			return File{}, false
 		}
+		if err == errReplacedFileNotFound {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return File{}, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return File{}, false
+	}
+
+	return created, true
+}
+
+// Наибольший размер файла для задачи с учётом предела её проекта.
+// Для несуществующей задачи — sql.ErrNoRows.
+func taskUploadLimit(db *sqlx.DB, uploads uploadPolicy, taskID interface{}) (int64, error) {
+	var projectLimit int64
+	err := db.Get(&projectLimit, "SELECT projects.max_file_size FROM tasks JOIN projects ON projects.id = tasks.project_id WHERE tasks.id = $1", taskID)
+	if err != nil {
+		return 0, err
+	}
+	return uploads.limit(projectLimit), nil
+}
+
+// Загрузившему сообщим, если файл не пройдёт проверку на вирусы
+func requestUploader(c *gin.Context) sql.NullInt64 {
+	if userID, ok := requestUserID(c); ok {
+		return sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	return sql.NullInt64{}
+}
+
+// Прикрепляет к задаче файл, уже загруженный в хранилище. replaces — id файла,
+// новой версией которого он станет, 0 — новый файл. Если файл не помещается
+// в квоту, возвращает *quotaError, а объект остаётся на совести вызывающего.
+func insertTaskFile(db *sqlx.DB, uploads uploadPolicy, file File, uploadedBy sql.NullInt64, blob fileBlob, replaces int) (File, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return File{}, err
+	}
+	defer tx.Rollback()
 
-		_, err = db.Exec("INSERT INTO files (task_id, object_name, name) VALUES ($1, $2, $3)", id, objectName, fileName)
+	var versionOf sql.NullInt64
+	version := 1
+	if replaces != 0 {
+		group, next, err := nextFileVersion(tx, file.TaskID, replaces)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return File{}, err
 		}
+		versionOf, version = sql.NullInt64{Int64: group, Valid: true}, next
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "File added"})
-	})
+	if err := chargeStorage(tx, uploads, file.TaskID, uploadedBy, file.Size); err != nil {
+		return File{}, err
+	}
+	if err := acquireBlob(tx, blob, file.Size, file.ContentType); err != nil {
+		return File{}, err
+	}
+
+	// Это содержимое уже проверено на вирусы
+	if file.ScanStatus == scanPending && blob.existing && blob.sha256 != "" {
+		var clean bool
+		if err := tx.Get(&clean, "SELECT EXISTS (SELECT 1 FROM files WHERE sha256 = $1 AND scan_status = $2)", blob.sha256, scanClean); err != nil {
+			return File{}, err
+		}
+		if clean {
+			file.ScanStatus = scanClean
+		}
+	}
+
+	sha256 := sql.NullString{String: blob.sha256, Valid: blob.sha256 != ""}
+
+	var created File
+	err = tx.QueryRow("INSERT INTO files (task_id, object_name, name, content_type, size, uploaded_by, scan_status, has_thumbnails, version_of, version, sha256) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, task_id, name, object_name, content_type, size, scan_status, version",
+		file.TaskID, file.FileUuid, file.Name, file.ContentType, file.Size, uploadedBy, file.ScanStatus, blob.hasThumbnails, versionOf, version, sha256).
+		Scan(&created.ID, &created.TaskID, &created.Name, &created.FileUuid, &created.ContentType, &created.Size, &created.ScanStatus, &created.Version)
+	if err != nil {
+		return File{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return File{}, err
+	}
+	created.Thumbnails = thumbnailURLs(created, blob.hasThumbnails)
+	return created, nil
 }
 
 // /profile/:id
@@ -992,66 +1133,18 @@ func profileHandler(db *sqlx.DB) gin.HandlerFunc {
 		user.Password = ""
 		user.ID, _ = strconv.Atoi(id)
 
-		err := db.Get(&user, "SELECT name, role, avatar FROM users WHERE id = $1", id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		// user.Avatar = []byte(base64.StdEncoding.EncodeToString(user.Avatar))
-		c.JSON(http.StatusOK, gin.H{"user": user})
-	})
-}
-
-type AvatarData struct {
-	Avatar string `json:"avatar"`
-}
-
-// /profile/:id/update_avatar
-func profileUpdateAvatarHandler(db *sqlx.DB) gin.HandlerFunc {
-	return gin.HandlerFunc(func(c *gin.Context) {
-		// id := c.Param("id")
-		file, header, err := c.Request.FormFile("image")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		}
-		defer file.Close()
-
-		accessKey := os.Getenv("ACCESS_KEY")
-		secretKey := os.Getenv("SECRET_KEY")
-		bucketName := os.Getenv("BUCKET_NAME_AVATARS")
-		regionName := os.Getenv("REGION_NAME")
-		objectName := header.Filename // Используем имя файла из заголовка
-
-		sess, err := session.NewSession(&aws.Config{
-			Region:      aws.String(regionName),
-			Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
-			Endpoint:    aws.String("https://storage.yandexcloud.net"),
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии: " + err.Error()})
+		err := db.Get(&user, "SELECT name, role, COALESCE(avatar_key, '') AS avatar FROM users WHERE id = $1", id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-
-		uploader := s3manager.NewUploader(sess)
-
-		// Загрузка файла
-		_, err = uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(objectName),
-			Body:   file,
-		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Файл %s успешно загружен в бакет %s", objectName, bucketName)})
-
-		c.JSON(http.StatusOK, gin.H{"message": "Avatar updated"})
+		user.Avatar = avatarURL(user.ID, user.Avatar)
+		c.JSON(http.StatusOK, gin.H{"user": user})
 	})
 }
 
@@ -1144,49 +1237,3 @@ func profileUpdateOnlineStatusHandler(db *sqlx.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"message": "Online status updated"})
 	})
 }
-
-func uploadImageHandler() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		fmt.Println("uploadImageHandler")
-		file, header, err := c.Request.FormFile("image")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		}
-		defer file.Close()
-
-		// Замените эти значения на свои
-		accessKey := "test"
-		secretKey := "test"
-		bucketName := "test"
-		regionName := "ru-central1"
-		objectName := header.Filename // Используем имя файла из заголовка
-
-		sess, err := session.NewSession(&aws.Config{
-			Region:      aws.String(regionName),
-			Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
-			Endpoint:    aws.String("https://storage.yandexcloud.net"),
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии: " + err.Error()})
-			return
-		}
-
-		uploader := s3manager.NewUploader(sess)
-
-		// Загрузка файла
-		_, err = uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(objectName),
-			Body:   file,
-		})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			fmt.Println("error: ", err.Error())
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Файл %s успешно загружен в бакет %s", objectName, bucketName)})
-	}
-}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type migration struct {
+	postgres string
+	// Вариант для SQLite, если синтаксис отличается
+	sqlite string
+}
+
+func (m migration) query(db *sqlx.DB) string {
+	if isSQLite(db) && m.sqlite != "" {
+		return m.sqlite
+	}
+	return m.postgres
+}
+
+// Миграции схемы. Применяются по порядку, номер версии = индекс + 1.
+// Уже применённые миграции менять нельзя, только добавлять новые в конец.
+var migrations = []migration{
+	// 1: ключи идемпотентности для create-запросов
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key text NOT NULL,
+			route text NOT NULL,
+			request_hash text NOT NULL,
+			status integer NOT NULL DEFAULT 0,
+			location text NOT NULL DEFAULT '',
+			body bytea,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (key, route)
+		)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key TEXT NOT NULL,
+			route TEXT NOT NULL,
+			request_hash TEXT NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			location TEXT NOT NULL DEFAULT '',
+			body BLOB,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (key, route)
+		)`,
+	},
+	// 2: история изменений задач
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS task_history (
+			id serial PRIMARY KEY,
+			task_id integer NOT NULL,
+			changed_at timestamptz NOT NULL DEFAULT now(),
+			changes jsonb NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS task_history_task_id_idx ON task_history (task_id)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS task_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task_id INTEGER NOT NULL,
+			changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			changes TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS task_history_task_id_idx ON task_history (task_id)`,
+	},
+	// 3: версии для оптимистичных блокировок
+	{
+		postgres: `ALTER TABLE tasks ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+		ALTER TABLE projects ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+		ALTER TABLE grants ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1`,
+		sqlite: `ALTER TABLE tasks ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE projects ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE grants ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	},
+	// 4: отключение пользователей из CLI
+	{
+		postgres: `ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false`,
+		sqlite:   `ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	// 5: очередь удаления объектов из хранилища
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS object_deletions (
+			id serial PRIMARY KEY,
+			store text NOT NULL,
+			object_key text NOT NULL,
+			attempts integer NOT NULL DEFAULT 0,
+			last_error text NOT NULL DEFAULT '',
+			run_at timestamptz NOT NULL DEFAULT now(),
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS object_deletions_run_at_idx ON object_deletions (run_at)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS object_deletions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			store TEXT NOT NULL,
+			object_key TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS object_deletions_run_at_idx ON object_deletions (run_at)`,
+	},
+	// 6: тип и размер вложений, предел размера файла для проекта
+	{
+		postgres: `ALTER TABLE files ADD COLUMN IF NOT EXISTS content_type text NOT NULL DEFAULT '';
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS size bigint NOT NULL DEFAULT 0;
+		ALTER TABLE projects ADD COLUMN IF NOT EXISTS max_file_size bigint NOT NULL DEFAULT 0`,
+		sqlite: `ALTER TABLE files ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+		ALTER TABLE files ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE projects ADD COLUMN max_file_size INTEGER NOT NULL DEFAULT 0`,
+	},
+	// 7: карантин вложений до проверки на вирусы, уведомления пользователей
+	{
+		postgres: `ALTER TABLE files ADD COLUMN IF NOT EXISTS uploaded_by integer;
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_status text NOT NULL DEFAULT 'clean';
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_attempts integer NOT NULL DEFAULT 0;
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_error text NOT NULL DEFAULT '';
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_after timestamptz;
+		CREATE INDEX IF NOT EXISTS files_scan_status_idx ON files (scan_status);
+		CREATE TABLE IF NOT EXISTS notifications (
+			id serial PRIMARY KEY,
+			user_id integer NOT NULL,
+			message text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id)`,
+		sqlite: `ALTER TABLE files ADD COLUMN uploaded_by INTEGER;
+		ALTER TABLE files ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'clean';
+		ALTER TABLE files ADD COLUMN scan_attempts INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE files ADD COLUMN scan_error TEXT NOT NULL DEFAULT '';
+		ALTER TABLE files ADD COLUMN scan_after TIMESTAMP;
+		CREATE INDEX IF NOT EXISTS files_scan_status_idx ON files (scan_status);
+		CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id)`,
+	},
+	// 8: миниатюры картинок
+	{
+		postgres: `ALTER TABLE files ADD COLUMN IF NOT EXISTS has_thumbnails boolean NOT NULL DEFAULT false`,
+		sqlite:   `ALTER TABLE files ADD COLUMN has_thumbnails BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	// 9: возобновляемые загрузки вложений по протоколу tus
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS resumable_uploads (
+			id text PRIMARY KEY,
+			task_id integer NOT NULL,
+			name text NOT NULL,
+			upload_length bigint NOT NULL,
+			upload_offset bigint NOT NULL DEFAULT 0,
+			object_name text NOT NULL DEFAULT '',
+			content_type text NOT NULL DEFAULT '',
+			multipart_id text NOT NULL DEFAULT '',
+			parts text NOT NULL DEFAULT '[]',
+			tail_size bigint NOT NULL DEFAULT 0,
+			uploaded_by integer,
+			file_id integer,
+			locked_until timestamptz,
+			expires_at timestamptz NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS resumable_uploads_expires_at_idx ON resumable_uploads (expires_at)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS resumable_uploads (
+			id TEXT PRIMARY KEY,
+			task_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			upload_length INTEGER NOT NULL,
+			upload_offset INTEGER NOT NULL DEFAULT 0,
+			object_name TEXT NOT NULL DEFAULT '',
+			content_type TEXT NOT NULL DEFAULT '',
+			multipart_id TEXT NOT NULL DEFAULT '',
+			parts TEXT NOT NULL DEFAULT '[]',
+			tail_size INTEGER NOT NULL DEFAULT 0,
+			uploaded_by INTEGER,
+			file_id INTEGER,
+			locked_until TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS resumable_uploads_expires_at_idx ON resumable_uploads (expires_at)`,
+	},
+	// 10: загрузки вложений напрямую в хранилище по подписанным ссылкам
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS direct_uploads (
+			id text PRIMARY KEY,
+			task_id integer NOT NULL,
+			name text NOT NULL,
+			object_name text NOT NULL,
+			content_type text NOT NULL DEFAULT '',
+			size bigint NOT NULL,
+			checksum text NOT NULL,
+			uploaded_by integer,
+			file_id integer,
+			locked_until timestamptz,
+			expires_at timestamptz NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS direct_uploads_expires_at_idx ON direct_uploads (expires_at)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS direct_uploads (
+			id TEXT PRIMARY KEY,
+			task_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			object_name TEXT NOT NULL,
+			content_type TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL,
+			checksum TEXT NOT NULL,
+			uploaded_by INTEGER,
+			file_id INTEGER,
+			locked_until TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS direct_uploads_expires_at_idx ON direct_uploads (expires_at)`,
+	},
+	// 11: версии вложений
+	{
+		postgres: `ALTER TABLE files ADD COLUMN IF NOT EXISTS version_of integer;
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+		CREATE UNIQUE INDEX IF NOT EXISTS files_version_idx ON files (version_of, version) WHERE version_of IS NOT NULL;
+		ALTER TABLE resumable_uploads ADD COLUMN IF NOT EXISTS replaces integer;
+		ALTER TABLE direct_uploads ADD COLUMN IF NOT EXISTS replaces integer`,
+		sqlite: `ALTER TABLE files ADD COLUMN version_of INTEGER;
+		ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+		CREATE UNIQUE INDEX IF NOT EXISTS files_version_idx ON files (version_of, version) WHERE version_of IS NOT NULL;
+		ALTER TABLE resumable_uploads ADD COLUMN replaces INTEGER;
+		ALTER TABLE direct_uploads ADD COLUMN replaces INTEGER`,
+	},
+	// 12: квоты и занятое место у проектов и пользователей
+	{
+		postgres: `ALTER TABLE projects ADD COLUMN IF NOT EXISTS storage_used bigint NOT NULL DEFAULT 0;
+		ALTER TABLE projects ADD COLUMN IF NOT EXISTS storage_quota bigint;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_used bigint NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_quota bigint;
+		UPDATE projects SET storage_used = (SELECT COALESCE(SUM(files.size), 0) FROM files JOIN tasks ON tasks.id = files.task_id WHERE tasks.project_id = projects.id);
+		UPDATE users SET storage_used = (SELECT COALESCE(SUM(files.size), 0) FROM files WHERE files.uploaded_by = users.id)`,
+		sqlite: `ALTER TABLE projects ADD COLUMN storage_used INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE projects ADD COLUMN storage_quota INTEGER;
+		ALTER TABLE users ADD COLUMN storage_used INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN storage_quota INTEGER;
+		UPDATE projects SET storage_used = (SELECT COALESCE(SUM(files.size), 0) FROM files JOIN tasks ON tasks.id = files.task_id WHERE tasks.project_id = projects.id);
+		UPDATE users SET storage_used = (SELECT COALESCE(SUM(files.size), 0) FROM files WHERE files.uploaded_by = users.id)`,
+	},
+	// 13: дедупликация вложений по SHA-256
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS blobs (
+			sha256 text PRIMARY KEY,
+			object_name text NOT NULL UNIQUE,
+			size bigint NOT NULL,
+			content_type text NOT NULL,
+			has_thumbnails boolean NOT NULL DEFAULT FALSE,
+			refs integer NOT NULL DEFAULT 0,
+			created_at timestamptz NOT NULL DEFAULT now()
+		);
+		ALTER TABLE files ADD COLUMN IF NOT EXISTS sha256 text;
+		CREATE INDEX IF NOT EXISTS files_sha256_idx ON files (sha256)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS blobs (
+			sha256 TEXT PRIMARY KEY,
+			object_name TEXT NOT NULL UNIQUE,
+			size INTEGER NOT NULL,
+			content_type TEXT NOT NULL,
+			has_thumbnails BOOLEAN NOT NULL DEFAULT FALSE,
+			refs INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE files ADD COLUMN sha256 TEXT;
+		CREATE INDEX IF NOT EXISTS files_sha256_idx ON files (sha256)`,
+	},
+	// 14: расписание фоновых задач
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS scheduled_jobs (
+			name text PRIMARY KEY,
+			run_at timestamptz NOT NULL
+		)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS scheduled_jobs (
+			name TEXT PRIMARY KEY,
+			run_at TIMESTAMP NOT NULL
+		)`,
+	},
+	// 15: ключ аватара в хранилище
+	{
+		postgres: `ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key text`,
+		sqlite:   `ALTER TABLE users ADD COLUMN avatar_key TEXT`,
+	},
+	// 16: колонки доски в отдельной таблице. Колонки из projects.columns_ и
+	// статусы задач без колонки переносятся в columns, задачи получают column_id,
+	// после чего projects.columns_ удаляется.
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS columns (
+			id serial PRIMARY KEY,
+			project_id integer NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			name text NOT NULL,
+			position integer NOT NULL,
+			color text NOT NULL DEFAULT '',
+			category text NOT NULL DEFAULT 'todo',
+			UNIQUE (project_id, name)
+		);
+		INSERT INTO columns (project_id, name, position)
+		SELECT project_id, name, ROW_NUMBER() OVER (PARTITION BY project_id ORDER BY MIN(position)) - 1
+		FROM (SELECT projects.id AS project_id, listed.name, listed.position FROM projects, unnest(projects.columns_) WITH ORDINALITY AS listed(name, position)) AS listed
+		WHERE name IS NOT NULL
+		GROUP BY project_id, name;
+		INSERT INTO columns (project_id, name, position)
+		SELECT tasks.project_id, tasks.status, (SELECT COUNT(*) FROM columns WHERE columns.project_id = tasks.project_id) + ROW_NUMBER() OVER (PARTITION BY tasks.project_id ORDER BY MIN(tasks.id)) - 1
+		FROM tasks
+		WHERE tasks.project_id IN (SELECT id FROM projects) AND NOT EXISTS (SELECT 1 FROM columns WHERE columns.project_id = tasks.project_id AND columns.name = tasks.status)
+		GROUP BY tasks.project_id, tasks.status;
+		ALTER TABLE tasks ADD COLUMN IF NOT EXISTS column_id integer REFERENCES columns(id);
+		UPDATE tasks SET column_id = (SELECT id FROM columns WHERE columns.project_id = tasks.project_id AND columns.name = tasks.status);
+		CREATE INDEX IF NOT EXISTS tasks_column_id_idx ON tasks (column_id);
+		ALTER TABLE projects DROP COLUMN IF EXISTS columns_`,
+		sqlite: `CREATE TABLE IF NOT EXISTS columns (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			color TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT 'todo',
+			UNIQUE (project_id, name)
+		);
+		INSERT INTO columns (project_id, name, position)
+		SELECT project_id, name, ROW_NUMBER() OVER (PARTITION BY project_id ORDER BY MIN(position)) - 1
+		FROM (SELECT projects.id AS project_id, listed.value AS name, listed.key AS position FROM projects, json_each(projects.columns_) AS listed)
+		WHERE name IS NOT NULL
+		GROUP BY project_id, name;
+		INSERT INTO columns (project_id, name, position)
+		SELECT tasks.project_id, tasks.status, (SELECT COUNT(*) FROM columns WHERE columns.project_id = tasks.project_id) + ROW_NUMBER() OVER (PARTITION BY tasks.project_id ORDER BY MIN(tasks.id)) - 1
+		FROM tasks
+		WHERE tasks.project_id IN (SELECT id FROM projects) AND NOT EXISTS (SELECT 1 FROM columns WHERE columns.project_id = tasks.project_id AND columns.name = tasks.status)
+		GROUP BY tasks.project_id, tasks.status;
+		ALTER TABLE tasks ADD COLUMN column_id INTEGER REFERENCES columns(id);
+		UPDATE tasks SET column_id = (SELECT id FROM columns WHERE columns.project_id = tasks.project_id AND columns.name = tasks.status);
+		CREATE INDEX IF NOT EXISTS tasks_column_id_idx ON tasks (column_id);
+		ALTER TABLE projects DROP COLUMN columns_`,
+	},
+	// 17: корзина удалённых колонок и снимки удалённых с ними задач
+	{
+		postgres: `CREATE TABLE IF NOT EXISTS column_trash (
+			id serial PRIMARY KEY,
+			project_id integer NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			column_id integer NOT NULL,
+			name text NOT NULL,
+			position integer NOT NULL,
+			color text NOT NULL DEFAULT '',
+			category text NOT NULL DEFAULT 'todo',
+			target_column_id integer,
+			tasks integer NOT NULL DEFAULT 0,
+			deleted_at timestamptz NOT NULL,
+			expires_at timestamptz NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS column_trash_expires_at_idx ON column_trash (expires_at);
+		CREATE TABLE IF NOT EXISTS trashed_tasks (
+			trash_id integer NOT NULL REFERENCES column_trash(id) ON DELETE CASCADE,
+			task_id integer NOT NULL,
+			task text,
+			PRIMARY KEY (trash_id, task_id)
+		)`,
+		sqlite: `CREATE TABLE IF NOT EXISTS column_trash (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			column_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			color TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT 'todo',
+			target_column_id INTEGER,
+			tasks INTEGER NOT NULL DEFAULT 0,
+			deleted_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS column_trash_expires_at_idx ON column_trash (expires_at);
+		CREATE TABLE IF NOT EXISTS trashed_tasks (
+			trash_id INTEGER NOT NULL REFERENCES column_trash(id) ON DELETE CASCADE,
+			task_id INTEGER NOT NULL,
+			task TEXT,
+			PRIMARY KEY (trash_id, task_id)
+		)`,
+	},
+	// 18: ключи идемпотентности действуют в пределах пользователя
+	{
+		postgres: `ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS caller text NOT NULL DEFAULT '';
+		ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+		ALTER TABLE idempotency_keys ADD PRIMARY KEY (key, route, caller)`,
+		sqlite: `CREATE TABLE idempotency_keys_new (
+			key TEXT NOT NULL,
+			route TEXT NOT NULL,
+			caller TEXT NOT NULL DEFAULT '',
+			request_hash TEXT NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			location TEXT NOT NULL DEFAULT '',
+			body BLOB,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (key, route, caller)
+		);
+		INSERT INTO idempotency_keys_new (key, route, request_hash, status, location, body, created_at)
+		SELECT key, route, request_hash, status, location, body, created_at FROM idempotency_keys;
+		DROP TABLE idempotency_keys;
+		ALTER TABLE idempotency_keys_new RENAME TO idempotency_keys`,
+	},
+}
+
+// Исходные таблицы. В Postgres они созданы заранее, новую базу SQLite
+// создаём с нуля перед миграциями.
+const sqliteBaseSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT '',
+	login TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	avatar BLOB,
+	status TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS projects (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	columns_ TEXT NOT NULL DEFAULT '[]'
+);
+CREATE TABLE IF NOT EXISTS user_projects (
+	user_id INTEGER NOT NULL,
+	project_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, project_id)
+);
+CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	descr TEXT,
+	date TEXT NOT NULL,
+	date_act TEXT,
+	empl_id INTEGER,
+	project_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	priority TEXT,
+	creator_id INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS grants (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	descr TEXT NOT NULL DEFAULT '',
+	num INTEGER NOT NULL DEFAULT 0,
+	project_id INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id INTEGER NOT NULL,
+	object_name TEXT NOT NULL,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// Применяет недостающие миграции и возвращает номера применённых
+func migrate(db *sqlx.DB) ([]int, error) {
+	var err error
+	if isSQLite(db) {
+		_, err = db.Exec(sqliteBaseSchema)
+	} else {
+		_, err = db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now())")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+	err = db.Get(&current, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return applied, err
+		}
+		if _, err := tx.Exec(migrations[i].query(db)); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, err
+		}
+		applied = append(applied, version)
+	}
+
+	return applied, nil
+}
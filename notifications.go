@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Сколько последних уведомлений отдаёт API
+const notificationsLimit = 50
+
+// Уведомление пишется в транзакции события, о котором оно сообщает
+func notifyUser(tx *sqlx.Tx, userID int64, message string) error {
+	_, err := tx.Exec("INSERT INTO notifications (user_id, message) VALUES ($1, $2)", userID, message)
+	return err
+}
+
+// GET /api/v1/users/:id/notifications
+func userNotificationsHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		notifications := []Notification{}
+		err := db.Select(&notifications, "SELECT id, user_id AS userid, message, created_at AS createdat FROM notifications WHERE user_id = $1 ORDER BY id DESC LIMIT $2", id, notificationsLimit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+	})
+}
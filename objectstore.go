@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errObjectNotFound      = errors.New("object not found")
+	errPresignNotSupported = errors.New("presigned URLs are not supported by this object store")
+)
+
+// Наименьший размер части при загрузке по частям, кроме последней (ограничение S3)
+const minPartSize = 5 << 20
+
+type ObjectInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Хранилище объектов одного бакета. Отсутствующий объект — errObjectNotFound,
+// удаление отсутствующего объекта не ошибка.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Часть объекта с offset, length < 0 — до конца
+	GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Копия объекта внутри бакета, Content-Type сохраняется
+	Copy(ctx context.Context, srcKey string, dstKey string) error
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// contentDisposition, если не пустой, подставляется в ответ хранилища
+	PresignGet(ctx context.Context, key string, contentDisposition string, expires time.Duration) (string, error)
+	// Ссылка на загрузку объекта ровно size байт
+	PresignPut(ctx context.Context, key string, contentType string, size int64, expires time.Duration) (string, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Загрузка по частям. Части нумеруются с 1, все, кроме последней,
+	// не меньше minPartSize. Объект появляется только после CompleteMultipart.
+	CreateMultipart(ctx context.Context, key string, contentType string) (string, error)
+	UploadPart(ctx context.Context, key string, uploadID string, number int, body io.ReadSeeker) (string, error)
+	CompleteMultipart(ctx context.Context, key string, uploadID string, parts []ObjectPart) error
+	AbortMultipart(ctx context.Context, key string, uploadID string) error
+}
+
+// Загруженная часть объекта
+type ObjectPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// Бакеты приложения. Создаются один раз при старте и передаются в обработчики.
+type objectStores struct {
+	files   ObjectStore
+	avatars ObjectStore
+}
+
+// Драйвер выбирается через OBJECT_STORE_DRIVER: s3 (по умолчанию), local или memory.
+// Имена бакетов берутся из BUCKET_NAME_FILES и BUCKET_NAME_AVATARS.
+func newObjectStores() (objectStores, error) {
+	filesBucket := os.Getenv("BUCKET_NAME_FILES")
+	avatarsBucket := os.Getenv("BUCKET_NAME_AVATARS")
+
+	switch driver := os.Getenv("OBJECT_STORE_DRIVER"); driver {
+	case "", "s3":
+		sess, err := newS3Session()
+		if err != nil {
+			return objectStores{}, err
+		}
+		return objectStores{files: newS3Store(sess, filesBucket), avatars: newS3Store(sess, avatarsBucket)}, nil
+	case "local":
+		root := os.Getenv("OBJECT_STORE_PATH")
+		if root == "" {
+			root = "objects"
+		}
+		if filesBucket == "" {
+			filesBucket = "files"
+		}
+		if avatarsBucket == "" {
+			avatarsBucket = "avatars"
+		}
+		return objectStores{
+			files:   newLocalStore(filepath.Join(root, filesBucket)),
+			avatars: newLocalStore(filepath.Join(root, avatarsBucket)),
+		}, nil
+	case "memory":
+		return objectStores{files: newMemoryStore(), avatars: newMemoryStore()}, nil
+	default:
+		return objectStores{}, fmt.Errorf("unknown OBJECT_STORE_DRIVER %q", driver)
+	}
+}
+
+func rangeReader(r io.ReadSeeker, offset int64, length int64) io.Reader {
+	r.Seek(offset, io.SeekStart)
+	if length < 0 {
+		return r
+	}
+	return io.LimitReader(r, length)
+}
+
+func contentTypeByKey(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// Объекты в памяти процесса, для разработки и тестов
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryMultipart
+}
+
+type memoryMultipart struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string]memoryObject), uploads: make(map[string]*memoryMultipart)}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = contentTypeByKey(key)
+	}
+
+	s.mu.Lock()
+	s.objects[key] = memoryObject{data: data, contentType: contentType, modTime: time.Now().UTC()}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	object, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ObjectInfo{}, errObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), object.info(key), nil
+}
+
+func (s *memoryStore) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	object, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	return io.NopCloser(rangeReader(bytes.NewReader(object.data), offset, length)), nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[srcKey]
+	if !ok {
+		return errObjectNotFound
+	}
+	object.modTime = time.Now().UTC()
+	s.objects[dstKey] = object
+	return nil
+}
+
+func (s *memoryStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	object, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return ObjectInfo{}, errObjectNotFound
+	}
+	return object.info(key), nil
+}
+
+func (s *memoryStore) PresignGet(ctx context.Context, key string, contentDisposition string, expires time.Duration) (string, error) {
+	return "", errPresignNotSupported
+}
+
+func (s *memoryStore) PresignPut(ctx context.Context, key string, contentType string, size int64, expires time.Duration) (string, error) {
+	return "", errPresignNotSupported
+}
+
+func (s *memoryStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := []ObjectInfo{}
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, object.info(key))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *memoryStore) CreateMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	uploadID := uuid.New().String()
+	s.mu.Lock()
+	s.uploads[uploadID] = &memoryMultipart{key: key, contentType: contentType, parts: make(map[int][]byte)}
+	s.mu.Unlock()
+	return uploadID, nil
+}
+
+func (s *memoryStore) UploadPart(ctx context.Context, key string, uploadID string, number int, body io.ReadSeeker) (string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return "", errObjectNotFound
+	}
+	upload.parts[number] = data
+	return strconv.Itoa(number), nil
+}
+
+func (s *memoryStore) CompleteMultipart(ctx context.Context, key string, uploadID string, parts []ObjectPart) error {
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		s.mu.Unlock()
+		return errObjectNotFound
+	}
+	var body bytes.Buffer
+	for _, part := range parts {
+		data, ok := upload.parts[part.Number]
+		if !ok {
+			s.mu.Unlock()
+			return fmt.Errorf("part %d was not uploaded", part.Number)
+		}
+		body.Write(data)
+	}
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+
+	return s.Put(ctx, key, &body, upload.contentType)
+}
+
+func (s *memoryStore) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{Key: key, Size: int64(len(o.data)), ContentType: o.contentType, ModTime: o.modTime}
+}
+
+// Объекты в каталоге на диске, ключ — относительный путь.
+// Content-Type не хранится и определяется по расширению ключа.
+type localStore struct {
+	root string
+}
+
+// Префикс временных файлов, которые ещё не переименованы в объект
+const localUploadPrefix = ".upload-"
+
+func newLocalStore(root string) *localStore {
+	return &localStore{root: root}
+}
+
+func (s *localStore) path(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || strings.HasPrefix(key, "/") || key == ".." || strings.HasPrefix(key, "../") || strings.HasPrefix(path.Base(key), localUploadPrefix) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не было недописанных объектов
+	tmp, err := os.CreateTemp(filepath.Dir(name), localUploadPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ObjectInfo{}, errObjectNotFound
+		}
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return file, localObjectInfo(key, stat), nil
+}
+
+func (s *localStore) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	body, _, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file := body.(*os.File)
+	return struct {
+		io.Reader
+		io.Closer
+	}{rangeReader(file, offset, length), file}, nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	body, _, err := s.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s.Put(ctx, dstKey, body, "")
+}
+
+func (s *localStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, errObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return localObjectInfo(key, stat), nil
+}
+
+func (s *localStore) PresignGet(ctx context.Context, key string, contentDisposition string, expires time.Duration) (string, error) {
+	return "", errPresignNotSupported
+}
+
+func (s *localStore) PresignPut(ctx context.Context, key string, contentType string, size int64, expires time.Duration) (string, error) {
+	return "", errPresignNotSupported
+}
+
+func (s *localStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(s.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && name == s.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), localUploadPrefix) {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), localUploadPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, localObjectInfo(key, stat))
+		return nil
+	})
+	return objects, err
+}
+
+// Части загрузки лежат в отдельном каталоге, который List пропускает
+func (s *localStore) multipartDir(uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", fmt.Errorf("invalid upload ID %q", uploadID)
+	}
+	return filepath.Join(s.root, localUploadPrefix+"multipart", uploadID), nil
+}
+
+func (s *localStore) CreateMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	uploadID := uuid.New().String()
+	dir, err := s.multipartDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	return uploadID, os.MkdirAll(dir, 0o755)
+}
+
+func (s *localStore) UploadPart(ctx context.Context, key string, uploadID string, number int, body io.ReadSeeker) (string, error) {
+	dir, err := s.multipartDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", errObjectNotFound
+		}
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, localUploadPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(number), os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(number)))
+}
+
+func (s *localStore) CompleteMultipart(ctx context.Context, key string, uploadID string, parts []ObjectPart) error {
+	dir, err := s.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(part.Number)))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("part %d was not uploaded", part.Number)
+			}
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	if err := s.Put(ctx, key, io.MultiReader(readers...), ""); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *localStore) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	dir, err := s.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func localObjectInfo(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{Key: key, Size: stat.Size(), ContentType: contentTypeByKey(key), ModTime: stat.ModTime().UTC()}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const defaultS3Endpoint = "https://storage.yandexcloud.net"
+
+// Сессия для S3-совместимого хранилища:
+//   - S3_ENDPOINT — адрес хранилища, по умолчанию Yandex Object Storage,
+//     пустое значение — стандартный адрес AWS;
+//   - REGION_NAME — регион;
+//   - S3_FORCE_PATH_STYLE=true — адреса вида endpoint/bucket/key (MinIO и т.п.);
+//   - ACCESS_KEY и SECRET_KEY — статические ключи. Если их нет, используется
+//     стандартная цепочка AWS: переменные AWS_*, ~/.aws, роль инстанса.
+func newS3Session() (*session.Session, error) {
+	config := aws.NewConfig().WithRegion(os.Getenv("REGION_NAME"))
+
+	endpoint, ok := os.LookupEnv("S3_ENDPOINT")
+	if !ok {
+		endpoint = defaultS3Endpoint
+	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	if pathStyle, err := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE")); err == nil {
+		config = config.WithS3ForcePathStyle(pathStyle)
+	}
+
+	if accessKey := os.Getenv("ACCESS_KEY"); accessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(accessKey, os.Getenv("SECRET_KEY"), ""))
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+type s3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+func newS3Store(sess *session.Session, bucket string) *s3Store {
+	return &s3Store{client: s3.New(sess), uploader: s3manager.NewUploader(sess), bucket: bucket}
+}
+
+func s3NotFound(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := s.uploader.UploadWithContext(ctx, input)
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		if s3NotFound(err) {
+			return nil, ObjectInfo{}, errObjectNotFound
+		}
+		return nil, ObjectInfo{}, err
+	}
+	info := ObjectInfo{
+		Key:         key,
+		Size:        aws.Int64Value(out.ContentLength),
+		ContentType: aws.StringValue(out.ContentType),
+		ModTime:     aws.TimeValue(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *s3Store) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key), Range: aws.String(byteRange)})
+	if err != nil {
+		if s3NotFound(err) {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil && !s3NotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *s3Store) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	_, err := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(url.PathEscape(s.bucket + "/" + srcKey)),
+	})
+	if err != nil && s3NotFound(err) {
+		return errObjectNotFound
+	}
+	return err
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		if s3NotFound(err) {
+			return ObjectInfo{}, errObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:         key,
+		Size:        aws.Int64Value(out.ContentLength),
+		ContentType: aws.StringValue(out.ContentType),
+		ModTime:     aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (s *s3Store) PresignGet(ctx context.Context, key string, contentDisposition string, expires time.Duration) (string, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+	req, _ := s.client.GetObjectRequest(input)
+	req.SetContext(ctx)
+	return req.Presign(expires)
+}
+
+// Content-Type и Content-Length входят в подпись, клиент должен отправить
+// такие же заголовки, и объект другого размера хранилище не примет
+func (s *s3Store) PresignPut(ctx context.Context, key string, contentType string, size int64, expires time.Duration) (string, error) {
+	input := &s3.PutObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key), ContentLength: aws.Int64(size)}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	req, _ := s.client.PutObjectRequest(input)
+	req.SetContext(ctx)
+	return req.Presign(expires)
+}
+
+func (s *s3Store) CreateMultipart(ctx context.Context, key string, contentType string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{Bucket: aws.String(s.bucket), Key: aws.String(key)}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	out, err := s.client.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.UploadId), nil
+}
+
+func (s *s3Store) UploadPart(ctx context.Context, key string, uploadID string, number int, body io.ReadSeeker) (string, error) {
+	out, err := s.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(number)),
+		Body:       body,
+	})
+	if err != nil {
+		if s3NoSuchUpload(err) {
+			return "", errObjectNotFound
+		}
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+func (s *s3Store) CompleteMultipart(ctx context.Context, key string, uploadID string, parts []ObjectPart) error {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{ETag: aws.String(part.ETag), PartNumber: aws.Int64(int64(part.Number))})
+	}
+	_, err := s.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil && s3NoSuchUpload(err) {
+		return errObjectNotFound
+	}
+	return err
+}
+
+func (s *s3Store) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	_, err := s.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil && !s3NoSuchUpload(err) {
+		return err
+	}
+	return nil
+}
+
+func s3NoSuchUpload(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.bucket)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	err := s.client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     aws.StringValue(object.Key),
+				Size:    aws.Int64Value(object.Size),
+				ModTime: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readObject(t *testing.T, body io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestObjectStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) ObjectStore{
+		"memory": func(t *testing.T) ObjectStore { return newMemoryStore() },
+		"local":  func(t *testing.T) ObjectStore { return newLocalStore(filepath.Join(t.TempDir(), "files")) },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+
+			// Пустое хранилище, каталога local ещё нет
+			objects, err := store.List(ctx, "")
+			if err != nil || len(objects) != 0 {
+				t.Fatalf("List of an empty store = %v, %v", objects, err)
+			}
+
+			for key, body := range map[string]string{
+				"tasks/1/a.txt": "0123456789",
+				"tasks/1/b.png": "png",
+				"tasks/2/c.txt": "other",
+			} {
+				if err := store.Put(ctx, key, strings.NewReader(body), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, tc := range []struct {
+				offset, length int64
+				want           string
+			}{
+				{0, -1, "0123456789"},
+				{3, 4, "3456"},
+				{7, -1, "789"},
+				{8, 10, "89"},
+			} {
+				body, err := store.GetRange(ctx, "tasks/1/a.txt", tc.offset, tc.length)
+				if got := readObject(t, body, err); got != tc.want {
+					t.Errorf("GetRange(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+				}
+			}
+			if _, err := store.GetRange(ctx, "tasks/1/missing", 0, -1); err != errObjectNotFound {
+				t.Errorf("GetRange of a missing object: %v, want errObjectNotFound", err)
+			}
+
+			objects, err = store.List(ctx, "tasks/1/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+			if strings.Join(keys, ",") != "tasks/1/a.txt,tasks/1/b.png" {
+				t.Errorf("List(tasks/1/) = %v", keys)
+			}
+			if objects[0].Size != 10 || objects[1].ContentType != "image/png" {
+				t.Errorf("List infos = %+v", objects)
+			}
+
+			// Незавершённая загрузка по частям не видна в List
+			uploadID, err := store.CreateMultipart(ctx, "tasks/3/big.bin", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.UploadPart(ctx, "tasks/3/big.bin", uploadID, 1, bytes.NewReader([]byte("part"))); err != nil {
+				t.Fatal(err)
+			}
+			objects, _ = store.List(ctx, "")
+			if len(objects) != 3 {
+				t.Errorf("List with a pending multipart upload = %+v", objects)
+			}
+			if err := store.CompleteMultipart(ctx, "tasks/3/big.bin", uploadID, []ObjectPart{{Number: 1}}); err != nil {
+				t.Fatal(err)
+			}
+			body, _, err := store.Get(ctx, "tasks/3/big.bin")
+			if got := readObject(t, body, err); got != "part" {
+				t.Errorf("completed object = %q", got)
+			}
+
+			if err := store.Delete(ctx, "tasks/1/a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ctx, "tasks/1/a.txt"); err != nil {
+				t.Errorf("second Delete: %v", err)
+			}
+			if _, err := store.Stat(ctx, "tasks/1/a.txt"); err != errObjectNotFound {
+				t.Errorf("Stat after Delete: %v, want errObjectNotFound", err)
+			}
+		})
+	}
+}
+
+func TestLocalStoreKeys(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStore(filepath.Join(root, "files"))
+	ctx := context.Background()
+
+	for _, key := range []string{"", "../escape", "/abs", "a/../b", "tasks/" + localUploadPrefix + "x"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), ""); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); err == nil {
+		t.Error("object was written outside the store")
+	}
+
+	// Недописанный временный файл не считается объектом
+	if err := os.MkdirAll(filepath.Join(root, "files", "tasks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "files", "tasks", localUploadPrefix+"123"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := store.List(ctx, "")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List = %+v, %v, want no objects", objects, err)
+	}
+}
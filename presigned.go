@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Загрузка вложений напрямую в хранилище, минуя сервер:
+//
+//	POST /api/v1/tasks/:id/files/uploads                    имя, размер, тип и SHA-256 файла
+//	PUT  upload_url                                          сам файл, в хранилище
+//	POST /api/v1/tasks/:id/files/uploads/:uploadId/confirm  проверить объект и прикрепить к задаче
+//
+// Размер входит в подпись ссылки. При подтверждении сервер ещё раз сверяет
+// размер и SHA-256 объекта с заявленными и определяет тип по содержимому.
+// Объект, который не прошёл проверку или не был подтверждён вовремя, удаляется.
+
+// Сколько действует ссылка на загрузку
+const directUploadURLTTL = time.Hour
+
+// Сколько ждём подтверждения. Больше срока ссылки, чтобы успела
+// закончиться загрузка, начатая перед самым его концом.
+const directUploadExpiration = 24 * time.Hour
+
+// Подтверждение держит загрузку, пока читает объект
+const directUploadLease = 10 * time.Minute
+
+type directUpload struct {
+	ID          string        `db:"id"`
+	TaskID      int           `db:"task_id"`
+	Name        string        `db:"name"`
+	ObjectName  string        `db:"object_name"`
+	ContentType string        `db:"content_type"`
+	Size        int64         `db:"size"`
+	Checksum    string        `db:"checksum"`
+	UploadedBy  sql.NullInt64 `db:"uploaded_by"`
+	FileID      sql.NullInt64 `db:"file_id"`
+	Replaces    sql.NullInt64 `db:"replaces"`
+	ExpiresAt   time.Time     `db:"expires_at"`
+}
+
+const directUploadColumns = "id, task_id, name, object_name, content_type, size, checksum, uploaded_by, file_id, replaces, expires_at"
+
+// POST /api/v1/tasks/:id/files/uploads
+func directUploadCreateHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		var request NewDirectUpload
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		request.SHA256 = strings.ToLower(request.SHA256)
+		if request.Name == "" || request.Size <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and a positive size are required"})
+			return
+		}
+		if checksum, err := hex.DecodeString(request.SHA256); err != nil || len(checksum) != sha256.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex encoded SHA-256 digest"})
+			return
+		}
+
+		limit, err := taskUploadLimit(db, uploads, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if request.Size > limit {
+			respondFileTooLarge(c, limit)
+			return
+		}
+		// Содержимое проверим при подтверждении, а имя можно проверить сразу
+		if executableExtensions[strings.ToLower(filepath.Ext(request.Name))] {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Executable files are not allowed"})
+			return
+		}
+		if request.Replaces < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "replaces must be a file id"})
+			return
+		}
+		if !checkReplaces(c, db, id, request.Replaces) {
+			return
+		}
+		if !checkQuota(c, db, uploads, id, requestUploader(c), request.Size) {
+			return
+		}
+
+		upload := directUpload{
+			ID:          uuid.New().String(),
+			Name:        request.Name,
+			ContentType: request.ContentType,
+			Size:        request.Size,
+			Checksum:    request.SHA256,
+			UploadedBy:  requestUploader(c),
+			ExpiresAt:   time.Now().UTC().Add(directUploadExpiration),
+		}
+		if request.Replaces != 0 {
+			upload.Replaces = sql.NullInt64{Int64: int64(request.Replaces), Valid: true}
+		}
+		upload.ObjectName = uuid.New().String()
+		if declared := mimetype.Lookup(request.ContentType); declared != nil {
+			upload.ObjectName += declared.Extension()
+		}
+
+		url, err := files.PresignPut(c.Request.Context(), upload.ObjectName, upload.ContentType, upload.Size, directUploadURLTTL)
+		if err != nil {
+			if err == errPresignNotSupported {
+				c.JSON(http.StatusNotImplemented, gin.H{"error": "The object store does not support direct uploads, use POST /api/v1/tasks/:id/files"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		err = db.QueryRow("INSERT INTO direct_uploads (id, task_id, name, object_name, content_type, size, checksum, uploaded_by, replaces, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING task_id",
+			upload.ID, id, upload.Name, upload.ObjectName, upload.ContentType, upload.Size, upload.Checksum, upload.UploadedBy, upload.Replaces, upload.ExpiresAt).Scan(&upload.TaskID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		headers := map[string]string{"Content-Length": strconv.FormatInt(upload.Size, 10)}
+		if upload.ContentType != "" {
+			headers["Content-Type"] = upload.ContentType
+		}
+		respondCreated(c, fmt.Sprintf("/api/v1/tasks/%d/files/uploads/%s", upload.TaskID, upload.ID), DirectUpload{
+			ID:        upload.ID,
+			UploadURL: url,
+			Method:    http.MethodPut,
+			Headers:   headers,
+			ObjectKey: upload.ObjectName,
+			ExpiresAt: upload.ExpiresAt,
+		})
+	})
+}
+
+// POST /api/v1/tasks/:id/files/uploads/:uploadId/confirm
+func directUploadConfirmHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id, uploadID := c.Param("id"), c.Param("uploadId")
+
+		// Захватываем загрузку, чтобы повторное подтверждение не создало второй файл
+		now := time.Now().UTC()
+		res, err := db.Exec("UPDATE direct_uploads SET locked_until = $1 WHERE id = $2 AND task_id = $3 AND (locked_until IS NULL OR locked_until <= $4)", now.Add(directUploadLease), uploadID, id, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			var count int
+			db.Get(&count, "SELECT COUNT(*) FROM direct_uploads WHERE id = $1 AND task_id = $2", uploadID, id)
+			if count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload is being confirmed by another request"})
+			return
+		}
+		defer db.Exec("UPDATE direct_uploads SET locked_until = NULL WHERE id = $1", uploadID)
+
+		var upload directUpload
+		if err := db.Get(&upload, "SELECT "+directUploadColumns+" FROM direct_uploads WHERE id = $1", uploadID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		// Повтор подтверждения, ответ на которое потерялся
+		if upload.FileID.Valid {
+			var file File
+			var hasThumbnails bool
+			err := db.QueryRow("SELECT id, task_id, name, object_name, content_type, size, scan_status, has_thumbnails, version FROM files WHERE id = $1", upload.FileID.Int64).
+				Scan(&file.ID, &file.TaskID, &file.Name, &file.FileUuid, &file.ContentType, &file.Size, &file.ScanStatus, &hasThumbnails, &file.Version)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					c.JSON(http.StatusNotFound, gin.H{"error": "File was deleted"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				fmt.Println("error: ", err.Error())
+				return
+			}
+			file.Thumbnails = thumbnailURLs(file, hasThumbnails)
+			c.JSON(http.StatusOK, file)
+			return
+		}
+		if upload.ExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+			return
+		}
+
+		file, err := confirmDirectUpload(c.Request.Context(), db, files, uploads, &upload)
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/tasks/%d/files/%d", file.TaskID, file.ID), file)
+	})
+}
+
+// Проверяет загруженный объект и прикрепляет его к задаче. Объект с чужим
+// SHA-256 остаётся: клиент может загрузить его заново по той же ссылке.
+// Объект чужого размера по подписанной ссылке не загрузить, поэтому он, как
+// и объект запрещённого типа, удаляется вместе с загрузкой.
+func confirmDirectUpload(ctx context.Context, db *sqlx.DB, files ObjectStore, uploads uploadPolicy, upload *directUpload) (File, error) {
+	info, err := files.Stat(ctx, upload.ObjectName)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return File{}, &uploadError{status: http.StatusConflict, message: "The file has not been uploaded yet"}
+		}
+		return File{}, err
+	}
+	if info.Size != upload.Size {
+		if err := discardDirectUpload(db, *upload); err != nil {
+			return File{}, err
+		}
+		return File{}, &uploadError{status: http.StatusConflict, message: fmt.Sprintf("Uploaded %d bytes, expected %d", info.Size, upload.Size)}
+	}
+
+	body, _, err := files.Get(ctx, upload.ObjectName)
+	if err != nil {
+		return File{}, err
+	}
+	defer body.Close()
+
+	content, detected, err := sniffUpload(body)
+	if err != nil {
+		return File{}, err
+	}
+	if err := uploads.check(detected, upload.Name); err != nil {
+		if discardErr := discardDirectUpload(db, *upload); discardErr != nil {
+			return File{}, discardErr
+		}
+		return File{}, &uploadError{status: http.StatusUnsupportedMediaType, message: err.Error()}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return File{}, err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != upload.Checksum {
+		return File{}, &uploadError{status: http.StatusConflict, message: fmt.Sprintf("SHA-256 of the uploaded file is %s, expected %s", checksum, upload.Checksum)}
+	}
+
+	file := File{TaskID: upload.TaskID, Name: upload.Name, ContentType: detected.String(), Size: info.Size, ScanStatus: uploads.initialScanStatus()}
+	key := blobKey(upload.Checksum, detected.Extension())
+	created, err := insertBlobFile(db, uploads, file, upload.UploadedBy, int(upload.Replaces.Int64), upload.Checksum, key, func(key string) (bool, error) {
+		return copyBlob(ctx, files, upload.ObjectName, key, detected)
+	})
+	if err != nil {
+		if discardErr := discardDirectUpload(db, *upload); discardErr != nil {
+			return File{}, discardErr
+		}
+		if err == errReplacedFileNotFound {
+			return File{}, &uploadError{status: http.StatusConflict, message: err.Error()}
+		}
+		return File{}, err
+	}
+
+	upload.FileID = sql.NullInt64{Int64: int64(created.ID), Valid: true}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return File{}, err
+	}
+	defer tx.Rollback()
+
+	// Содержимое теперь лежит под своим адресом
+	if err := enqueueObjectDeletion(tx, filesStoreName, upload.ObjectName); err != nil {
+		return File{}, err
+	}
+	if _, err := tx.Exec("UPDATE direct_uploads SET file_id = $1 WHERE id = $2", created.ID, upload.ID); err != nil {
+		return File{}, err
+	}
+	return created, tx.Commit()
+}
+
+// Удаляет загрузку и ставит её объект в очередь на удаление
+func discardDirectUpload(db *sqlx.DB, upload directUpload) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueueObjectDeletion(tx, filesStoreName, upload.ObjectName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM direct_uploads WHERE id = $1", upload.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Удаляет неподтверждённые вовремя загрузки вместе с объектами, если их
+// успели загрузить. От подтверждённых остаются только файлы задач.
+func expireDirectUploads(db *sqlx.DB) (int, error) {
+	now := time.Now().UTC()
+
+	var expired []directUpload
+	err := db.Select(&expired, "SELECT "+directUploadColumns+" FROM direct_uploads WHERE expires_at <= $1 AND (locked_until IS NULL OR locked_until <= $1) LIMIT $2", now, cleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, upload := range expired {
+		if upload.FileID.Valid {
+			_, err = db.Exec("DELETE FROM direct_uploads WHERE id = $1", upload.ID)
+		} else {
+			err = discardDirectUpload(db, upload)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
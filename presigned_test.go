@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Хранилище в памяти, которое выдаёт ссылки на загрузку и запоминает,
+// какой размер в них подписан. Сам PUT тест делает через Put.
+type presignStore struct {
+	*memoryStore
+	sizes map[string]int64
+}
+
+func (s *presignStore) PresignPut(ctx context.Context, key string, contentType string, size int64, expires time.Duration) (string, error) {
+	s.sizes[key] = size
+	return "memory://" + key, nil
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDirectUpload(t *testing.T) {
+	files := &presignStore{memoryStore: newMemoryStore(), sizes: map[string]int64{}}
+	stores := objectStores{files: files, avatars: newMemoryStore()}
+	srv, db := newTestServerWith(t, stores, uploadPolicy{maxSize: 1 << 20})
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadsPath := "/api/v1/tasks/" + strconv.Itoa(task.ID) + "/files/uploads"
+	content := "hello, world"
+
+	create := func(t *testing.T) DirectUpload {
+		t.Helper()
+		var upload DirectUpload
+		resp := doJSON(t, srv, http.MethodPost, uploadsPath, NewDirectUpload{Name: "notes.txt", Size: int64(len(content)), ContentType: "text/plain", SHA256: sha256Hex(content)}, nil, &upload)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create: status %d", resp.StatusCode)
+		}
+		return upload
+	}
+	confirm := func(t *testing.T, upload DirectUpload, out interface{}) int {
+		t.Helper()
+		return doJSON(t, srv, http.MethodPost, uploadsPath+"/"+upload.ID+"/confirm", nil, nil, out).StatusCode
+	}
+	queued := func(t *testing.T, key string) bool {
+		t.Helper()
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM object_deletions WHERE store = $1 AND object_key = $2", filesStoreName, key); err != nil {
+			t.Fatal(err)
+		}
+		return count > 0
+	}
+
+	t.Run("size is signed", func(t *testing.T) {
+		upload := create(t)
+		if files.sizes[upload.ObjectKey] != int64(len(content)) {
+			t.Errorf("signed size %d, want %d", files.sizes[upload.ObjectKey], len(content))
+		}
+		if upload.Headers["Content-Length"] != strconv.Itoa(len(content)) || upload.Headers["Content-Type"] != "text/plain" {
+			t.Errorf("headers = %v", upload.Headers)
+		}
+	})
+
+	t.Run("wrong size", func(t *testing.T) {
+		upload := create(t)
+		if err := files.Put(ctx, upload.ObjectKey, strings.NewReader(content+"!"), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if status := confirm(t, upload, nil); status != http.StatusConflict {
+			t.Fatalf("confirm: status %d, want 409", status)
+		}
+		if !queued(t, upload.ObjectKey) {
+			t.Error("object of a wrong size is not queued for deletion")
+		}
+		if status := confirm(t, upload, nil); status != http.StatusNotFound {
+			t.Errorf("second confirm: status %d, want 404", status)
+		}
+		if _, _, err := cleanupObjects(ctx, db, stores); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := files.Stat(ctx, upload.ObjectKey); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("object of a wrong size: %v, want errObjectNotFound", err)
+		}
+	})
+
+	t.Run("bad sha256 and replay", func(t *testing.T) {
+		upload := create(t)
+		if status := confirm(t, upload, nil); status != http.StatusConflict {
+			t.Fatalf("confirm before PUT: status %d, want 409", status)
+		}
+		if err := files.Put(ctx, upload.ObjectKey, strings.NewReader(strings.ToUpper(content)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if status := confirm(t, upload, nil); status != http.StatusConflict {
+			t.Fatalf("confirm with a bad SHA-256: status %d, want 409", status)
+		}
+		if queued(t, upload.ObjectKey) {
+			t.Error("object with a bad SHA-256 is queued for deletion, it can still be uploaded again")
+		}
+
+		// Загружен заново по той же ссылке
+		if err := files.Put(ctx, upload.ObjectKey, strings.NewReader(content), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		var created, replayed File
+		if status := confirm(t, upload, &created); status != http.StatusCreated {
+			t.Fatalf("confirm: status %d, want 201", status)
+		}
+		if status := confirm(t, upload, &replayed); status != http.StatusOK {
+			t.Fatalf("repeated confirm: status %d, want 200", status)
+		}
+		if replayed.ID != created.ID || replayed.Size != int64(len(content)) {
+			t.Errorf("repeated confirm returned %+v, want %+v", replayed, created)
+		}
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM files WHERE task_id = $1", task.ID); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%d files, want 1", count)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		upload := create(t)
+		if err := files.Put(ctx, upload.ObjectKey, strings.NewReader(content), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		db.MustExec("UPDATE direct_uploads SET expires_at = $1 WHERE id = $2", time.Now().UTC().Add(-time.Minute), upload.ID)
+		if status := confirm(t, upload, nil); status != http.StatusGone {
+			t.Fatalf("confirm of an expired upload: status %d, want 410", status)
+		}
+
+		expired, err := expireDirectUploads(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired != 1 {
+			t.Errorf("expired %d uploads, want 1", expired)
+		}
+		if status := confirm(t, upload, nil); status != http.StatusNotFound {
+			t.Errorf("confirm after expiry: status %d, want 404", status)
+		}
+		if !queued(t, upload.ObjectKey) {
+			t.Error("object of an expired upload is not queued for deletion")
+		}
+	})
+}
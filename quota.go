@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Учёт места под вложения. У проектов и пользователей есть счётчик
+// storage_used, он меняется вместе с таблицей files: insertTaskFile прибавляет
+// размер файла, deleteFiles вычитает. Каждая версия файла считается отдельно,
+// даже если версии делят объект в хранилище.
+//
+// Квота — storage_quota проекта или пользователя, если она не задана, то
+// PROJECT_STORAGE_QUOTA или USER_STORAGE_QUOTA. 0 — без ограничения. Квота
+// проверяется до приёма файла и ещё раз при записи в files, уже атомарно.
+
+const projectQuotaQuery = "SELECT projects.storage_used, COALESCE(projects.storage_quota, $1) FROM tasks JOIN projects ON projects.id = tasks.project_id WHERE tasks.id = $2"
+
+const userQuotaQuery = "SELECT storage_used, COALESCE(storage_quota, $1) FROM users WHERE id = $2"
+
+type quotaError struct {
+	scope string
+	used  int64
+	quota int64
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("Storage quota of the %s is exceeded: %d of %d bytes used", e.scope, e.used, e.quota)
+}
+
+func respondQuotaExceeded(c *gin.Context, err *quotaError) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "used": err.used, "quota": err.quota})
+}
+
+// Занятое место и квота по projectQuotaQuery или userQuotaQuery
+func readQuota(q sqlx.Queryer, scope string, query string, defaultQuota int64, id interface{}) (*quotaError, error) {
+	usage := &quotaError{scope: scope}
+	err := q.QueryRowx(query, defaultQuota, id).Scan(&usage.used, &usage.quota)
+	return usage, err
+}
+
+// Поместится ли файл size в квоты проекта задачи и загрузившего.
+// Если нет, возвращает *quotaError.
+func storageAvailable(q sqlx.Queryer, uploads uploadPolicy, taskID interface{}, uploadedBy sql.NullInt64, size int64) error {
+	usage, err := readQuota(q, "project", projectQuotaQuery, uploads.projectQuota, taskID)
+	if err != nil {
+		return err
+	}
+	if usage.quota > 0 && usage.used+size > usage.quota {
+		return usage
+	}
+	if !uploadedBy.Valid {
+		return nil
+	}
+
+	usage, err = readQuota(q, "user", userQuotaQuery, uploads.userQuota, uploadedBy.Int64)
+	// X-User-ID может указывать на несуществующего пользователя, считать не на кого
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if usage.quota > 0 && usage.used+size > usage.quota {
+		return usage
+	}
+	return nil
+}
+
+// Проверяет квоты до приёма файла. При ошибке сам отвечает клиенту и возвращает false.
+func checkQuota(c *gin.Context, db *sqlx.DB, uploads uploadPolicy, taskID interface{}, uploadedBy sql.NullInt64, size int64) bool {
+	err := storageAvailable(db, uploads, taskID, uploadedBy, size)
+	if err == nil {
+		return true
+	}
+	if usage, ok := err.(*quotaError); ok {
+		respondQuotaExceeded(c, usage)
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	fmt.Println("error: ", err.Error())
+	return false
+}
+
+// Прибавляет файл к счётчикам проекта и загрузившего. Счётчик меняется только
+// если после этого он не превысит квоту, иначе возвращается *quotaError.
+func chargeStorage(tx *sqlx.Tx, uploads uploadPolicy, taskID int, uploadedBy sql.NullInt64, size int64) error {
+	res, err := tx.Exec("UPDATE projects SET storage_used = storage_used + $1 WHERE id = (SELECT project_id FROM tasks WHERE id = $2) AND (COALESCE(storage_quota, $3) = 0 OR storage_used + $1 <= COALESCE(storage_quota, $3))",
+		size, taskID, uploads.projectQuota)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		usage, err := readQuota(tx, "project", projectQuotaQuery, uploads.projectQuota, taskID)
+		if err != nil {
+			return err
+		}
+		return usage
+	}
+	if !uploadedBy.Valid {
+		return nil
+	}
+
+	res, err = tx.Exec("UPDATE users SET storage_used = storage_used + $1 WHERE id = $2 AND (COALESCE(storage_quota, $3) = 0 OR storage_used + $1 <= COALESCE(storage_quota, $3))",
+		size, uploadedBy.Int64, uploads.userQuota)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		usage, err := readQuota(tx, "user", userQuotaQuery, uploads.userQuota, uploadedBy.Int64)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return usage
+	}
+	return nil
+}
+
+// Вычитает из счётчиков файлы из fileQuery, до их удаления
+func releaseStorage(tx *sqlx.Tx, fileQuery string, args ...interface{}) error {
+	_, err := tx.Exec("UPDATE projects SET storage_used = storage_used - (SELECT COALESCE(SUM(files.size), 0) FROM files JOIN tasks ON tasks.id = files.task_id WHERE tasks.project_id = projects.id AND files.id IN ("+fileQuery+")) WHERE id IN (SELECT tasks.project_id FROM files JOIN tasks ON tasks.id = files.task_id WHERE files.id IN ("+fileQuery+"))", args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET storage_used = storage_used - (SELECT COALESCE(SUM(files.size), 0) FROM files WHERE files.uploaded_by = users.id AND files.id IN ("+fileQuery+")) WHERE id IN (SELECT uploaded_by FROM files WHERE id IN ("+fileQuery+"))", args...)
+	return err
+}
+
+// GET /api/v1/projects/:id/storage
+func projectStorageHandler(db *sqlx.DB, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		storage := ProjectStorage{ByTask: []TaskStorage{}, ByType: []TypeStorage{}}
+		err := db.QueryRow("SELECT id, storage_used, COALESCE(storage_quota, $1) FROM projects WHERE id = $2", uploads.projectQuota, id).
+			Scan(&storage.ProjectID, &storage.Used, &storage.Quota)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		storage.ByTask, err = projectStorageByTask(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		for _, task := range storage.ByTask {
+			storage.Files += task.Files
+		}
+
+		// Задачи, удалённые вместе с колонкой, занимают место до очистки корзины
+		err = db.QueryRow("SELECT COALESCE(SUM(size), 0), COUNT(*) FROM files WHERE task_id IN ("+trashedProjectTaskQuery+")", id).
+			Scan(&storage.Trash.Size, &storage.Trash.Files)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		storage.Files += storage.Trash.Files
+
+		storage.ByType, err = projectStorageByType(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, storage)
+	})
+}
+
+func projectStorageByTask(db *sqlx.DB, projectID string) ([]TaskStorage, error) {
+	rows, err := db.Query("SELECT tasks.id, tasks.name, SUM(files.size), COUNT(*) FROM files JOIN tasks ON tasks.id = files.task_id WHERE tasks.project_id = $1 GROUP BY tasks.id, tasks.name ORDER BY SUM(files.size) DESC, tasks.id", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byTask := []TaskStorage{}
+	for rows.Next() {
+		var task TaskStorage
+		if err := rows.Scan(&task.TaskID, &task.Name, &task.Size, &task.Files); err != nil {
+			return nil, err
+		}
+		byTask = append(byTask, task)
+	}
+	return byTask, rows.Err()
+}
+
+// Типы, которые отличаются только параметрами, вроде text/plain и
+// text/plain; charset=utf-8, складываются. Файлы из корзины тоже считаются.
+func projectStorageByType(db *sqlx.DB, projectID string) ([]TypeStorage, error) {
+	rows, err := db.Query("SELECT content_type, SUM(size), COUNT(*) FROM files WHERE task_id IN (SELECT id FROM tasks WHERE project_id = $1) OR task_id IN ("+trashedProjectTaskQuery+") GROUP BY content_type", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byType := []TypeStorage{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var item TypeStorage
+		if err := rows.Scan(&item.ContentType, &item.Size, &item.Files); err != nil {
+			return nil, err
+		}
+		item.ContentType = contentMediaType(item.ContentType)
+		if i, ok := index[item.ContentType]; ok {
+			byType[i].Size += item.Size
+			byType[i].Files += item.Files
+			continue
+		}
+		index[item.ContentType] = len(byType)
+		byType = append(byType, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(byType, func(i, j int) bool {
+		if byType[i].Size != byType[j].Size {
+			return byType[i].Size > byType[j].Size
+		}
+		return byType[i].ContentType < byType[j].ContentType
+	})
+	return byType, nil
+}
+
+// GET /api/v1/users/:id/storage
+func userStorageHandler(db *sqlx.DB, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		var storage UserStorage
+		err := db.QueryRow("SELECT id, storage_used, COALESCE(storage_quota, $1) FROM users WHERE id = $2", uploads.userQuota, c.Param("id")).
+			Scan(&storage.UserID, &storage.Used, &storage.Quota)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, storage)
+	})
+}
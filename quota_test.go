@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Загружает файл формой от имени userID и разбирает ответ
+func postTaskFile(t *testing.T, srv *httptest.Server, taskID int, userID int, name string, content string, out interface{}) int {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/v1/tasks/%d/files", srv.URL, taskID), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestStorageQuota(t *testing.T) {
+	for _, tc := range []struct {
+		scope   string
+		uploads uploadPolicy
+	}{
+		{"project", uploadPolicy{maxSize: 1 << 20, projectQuota: 10}},
+		{"user", uploadPolicy{maxSize: 1 << 20, userQuota: 10}},
+	} {
+		t.Run(tc.scope, func(t *testing.T) {
+			srv, db := newTestServerWith(t, objectStores{files: newMemoryStore(), avatars: newMemoryStore()}, tc.uploads)
+			c := client.New(srv.URL)
+			user, project := newTestBoard(t, c)
+			c.SetUserID(user.ID)
+			ctx := context.Background()
+
+			task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkUsed := func(want int64) {
+				t.Helper()
+				var projectUsed, userUsed int64
+				if err := db.QueryRow("SELECT projects.storage_used, users.storage_used FROM projects, users WHERE projects.id = $1 AND users.id = $2", project.ID, user.ID).Scan(&projectUsed, &userUsed); err != nil {
+					t.Fatal(err)
+				}
+				if projectUsed != want || userUsed != want {
+					t.Errorf("storage_used of the project %d and the user %d, want %d", projectUsed, userUsed, want)
+				}
+			}
+
+			var first, second File
+			if status := postTaskFile(t, srv, task.ID, user.ID, "a.txt", "123456", &first); status != http.StatusCreated {
+				t.Fatalf("first upload: status %d", status)
+			}
+			if status := postTaskFile(t, srv, task.ID, user.ID, "b.txt", "1234", &second); status != http.StatusCreated {
+				t.Fatalf("upload filling the quota: status %d", status)
+			}
+
+			var exceeded struct {
+				Error string `json:"error"`
+				Used  int64  `json:"used"`
+				Quota int64  `json:"quota"`
+			}
+			if status := postTaskFile(t, srv, task.ID, user.ID, "c.txt", "x", &exceeded); status != http.StatusRequestEntityTooLarge {
+				t.Fatalf("upload over the quota: status %d, want 413", status)
+			}
+			if exceeded.Used != 10 || exceeded.Quota != 10 || !strings.Contains(exceeded.Error, "quota of the "+tc.scope) {
+				t.Errorf("413 body = %+v", exceeded)
+			}
+
+			checkUsed(10)
+
+			// Место освобождается и при удалении файла, и при удалении задачи
+			if err := c.DeleteTaskFile(ctx, task.ID, first.ID); err != nil {
+				t.Fatal(err)
+			}
+			checkUsed(4)
+			if err := c.DeleteTask(ctx, task.ID); err != nil {
+				t.Fatal(err)
+			}
+			checkUsed(0)
+		})
+	}
+}
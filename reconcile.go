@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Сверка таблицы files с бакетом. Загрузка объекта и запись в files не
+// атомарны, поэтому со временем появляются объекты без записей и записи без
+// объектов. Сверку запускает команда files reconcile, а также сервер, если
+// задан RECONCILE_INTERVAL, например 24h. Исправление:
+//   - объект без записи ставится в очередь на удаление;
+//   - запись без объекта удаляется, место и ссылки освобождаются как при
+//     обычном удалении;
+//   - счётчики ссылок в blobs выравниваются по таблице files.
+//
+// Сервер только пишет найденное в лог, исправляет он при RECONCILE_REPAIR=true.
+
+// Объекты моложе этого могут принадлежать загрузке, которая ещё не записала файл
+const reconcileGrace = time.Hour
+
+// Как часто сервер проверяет, не пора ли запустить сверку
+const reconcileCheckInterval = time.Minute
+
+const reconcileJobName = "reconcile"
+
+type reconcileReport struct {
+	// Записи в таблице files, для которых нет объекта в бакете
+	MissingObjects []File `json:"missing_objects"`
+	// Объекты в бакете, на которые не ссылается ни одна запись
+	OrphanObjects []string `json:"orphan_objects"`
+	// Содержимое, у которого счётчик ссылок расходится с таблицей files
+	BlobRefs []blobRefs `json:"blob_refs"`
+}
+
+type blobRefs struct {
+	SHA256        string `json:"sha256" db:"sha256"`
+	ObjectName    string `json:"object_name" db:"object_name"`
+	HasThumbnails bool   `json:"-" db:"has_thumbnails"`
+	Refs          int    `json:"refs" db:"refs"`
+	Actual        int    `json:"actual" db:"actual"`
+}
+
+func (r reconcileReport) empty() bool {
+	return len(r.MissingObjects) == 0 && len(r.OrphanObjects) == 0 && len(r.BlobRefs) == 0
+}
+
+func (r reconcileReport) String() string {
+	return fmt.Sprintf("%d missing, %d orphan, %d blob refs", len(r.MissingObjects), len(r.OrphanObjects), len(r.BlobRefs))
+}
+
+// Сравнивает таблицу files с бакетом, ничего не меняя
+func reconcileFiles(ctx context.Context, db *sqlx.DB, files ObjectStore) (reconcileReport, error) {
+	report := reconcileReport{MissingObjects: []File{}, OrphanObjects: []string{}, BlobRefs: []blobRefs{}}
+
+	// Сначала записи, потом бакет: объект пишется раньше записи, поэтому
+	// у каждой прочитанной записи объект уже должен быть в списке
+	var rows []File
+	query, err := db.Query("SELECT id, task_id, name, object_name FROM files ORDER BY id")
+	if err != nil {
+		return report, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var file File
+		if err := query.Scan(&file.ID, &file.TaskID, &file.Name, &file.FileUuid); err != nil {
+			return report, err
+		}
+		rows = append(rows, file)
+	}
+	if err := query.Err(); err != nil {
+		return report, err
+	}
+
+	listed, err := files.List(ctx, "")
+	if err != nil {
+		return report, err
+	}
+	objects := make(map[string]ObjectInfo, len(listed))
+	for _, object := range listed {
+		objects[object.Key] = object
+	}
+
+	referenced := make(map[string]bool)
+	for _, file := range rows {
+		referenced[file.FileUuid] = true
+		if _, ok := objects[file.FileUuid]; !ok {
+			report.MissingObjects = append(report.MissingObjects, file)
+		}
+	}
+
+	// Объекты прямых загрузок до подтверждения ещё не файлы, но и не сироты
+	var unconfirmed []string
+	if err := db.Select(&unconfirmed, "SELECT object_name FROM direct_uploads WHERE file_id IS NULL"); err != nil {
+		return report, err
+	}
+	for _, key := range unconfirmed {
+		referenced[key] = true
+	}
+	// Объекты из очереди удаления и так скоро исчезнут, а содержимое
+	// с неверным счётчиком ссылок исправляется отдельно
+	var known []string
+	if err := db.Select(&known, "SELECT object_key FROM object_deletions WHERE store = $1 UNION SELECT object_name FROM blobs", filesStoreName); err != nil {
+		return report, err
+	}
+	for _, key := range known {
+		referenced[key] = true
+	}
+
+	cutoff := time.Now().Add(-reconcileGrace)
+	for key, object := range objects {
+		// Миниатюры принадлежат своему оригиналу
+		source, isThumbnail := thumbnailSource(key)
+		if isThumbnail && referenced[source] {
+			continue
+		}
+		// Хвосты незавершённых загрузок удаляются вместе с загрузкой
+		if strings.HasPrefix(key, resumablePrefix) {
+			continue
+		}
+		if referenced[key] || object.ModTime.After(cutoff) {
+			continue
+		}
+		report.OrphanObjects = append(report.OrphanObjects, key)
+	}
+	sort.Strings(report.OrphanObjects)
+
+	actual := "(SELECT COUNT(*) FROM files WHERE files.sha256 = blobs.sha256)"
+	err = db.Select(&report.BlobRefs, "SELECT sha256, object_name, has_thumbnails, refs, "+actual+" AS actual FROM blobs WHERE refs <> "+actual+" ORDER BY sha256")
+	return report, err
+}
+
+// Исправляет найденное сверкой
+func repairFiles(ctx context.Context, db *sqlx.DB, files ObjectStore, report reconcileReport) error {
+	// Пустой список объектов при непустой таблице скорее значит, что выбран
+	// не тот бакет, чем что пропали все файлы
+	if len(report.MissingObjects) > 0 {
+		listed, err := files.List(ctx, "")
+		if err != nil {
+			return err
+		}
+		if len(listed) == 0 {
+			return errors.New("the bucket is empty, refusing to remove file records")
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range report.OrphanObjects {
+		if err := enqueueObjectDeletion(tx, filesStoreName, key); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range report.MissingObjects {
+		// Перепроверяем по одному, список бакета мог устареть
+		if _, err := files.Stat(ctx, file.FileUuid); !errors.Is(err, errObjectNotFound) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := deleteFiles(tx, "SELECT id FROM files WHERE id = $1", file.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, blob := range report.BlobRefs {
+		_, err := tx.Exec("UPDATE blobs SET refs = (SELECT COUNT(*) FROM files WHERE files.sha256 = blobs.sha256) WHERE sha256 = $1", blob.SHA256)
+		if err != nil {
+			return err
+		}
+		if blob.Actual > 0 {
+			continue
+		}
+		res, err := tx.Exec("DELETE FROM blobs WHERE sha256 = $1 AND refs = 0", blob.SHA256)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		for _, key := range (fileBlob{objectName: blob.ObjectName, hasThumbnails: blob.HasThumbnails}).keys() {
+			if err := enqueueObjectDeletion(tx, filesStoreName, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Расписание сверки на сервере: RECONCILE_INTERVAL — период, пусто — не
+// запускать; RECONCILE_REPAIR=true — исправлять найденное
+type reconcileSchedule struct {
+	interval time.Duration
+	repair   bool
+}
+
+func newReconcileSchedule() (reconcileSchedule, error) {
+	var schedule reconcileSchedule
+	if value := os.Getenv("RECONCILE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return schedule, fmt.Errorf("RECONCILE_INTERVAL: expected a duration like 24h, got %q", value)
+		}
+		schedule.interval = interval
+	}
+	if value := os.Getenv("RECONCILE_REPAIR"); value != "" {
+		repair, err := strconv.ParseBool(value)
+		if err != nil {
+			return schedule, fmt.Errorf("RECONCILE_REPAIR: %w", err)
+		}
+		schedule.repair = repair
+	}
+	return schedule, nil
+}
+
+// Фоновая сверка, работает до отмены ctx. Из нескольких экземпляров
+// сервера сверку запускает тот, кто первым захватит её в scheduled_jobs.
+func runReconciler(ctx context.Context, db *sqlx.DB, files ObjectStore, schedule reconcileSchedule) {
+	ticker := time.NewTicker(reconcileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		due, err := claimScheduledJob(db, reconcileJobName, schedule.interval)
+		if err != nil {
+			fmt.Println("error: reconcile: ", err.Error())
+		}
+		if due {
+			if err := reconcileOnce(ctx, db, files, schedule.repair); err != nil {
+				fmt.Println("error: reconcile: ", err.Error())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func reconcileOnce(ctx context.Context, db *sqlx.DB, files ObjectStore, repair bool) error {
+	report, err := reconcileFiles(ctx, db, files)
+	if err != nil {
+		return err
+	}
+	if report.empty() {
+		return nil
+	}
+	if !repair {
+		fmt.Println("reconcile: found", report.String()+", run files reconcile for details")
+		return nil
+	}
+	if err := repairFiles(ctx, db, files, report); err != nil {
+		return err
+	}
+	fmt.Println("reconcile: repaired", report.String())
+	return nil
+}
+
+// Захватывает задание name, если подошёл его срок, и переносит срок на interval вперёд
+func claimScheduledJob(db *sqlx.DB, name string, interval time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	if _, err := db.Exec("INSERT INTO scheduled_jobs (name, run_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", name, now); err != nil {
+		return false, err
+	}
+	res, err := db.Exec("UPDATE scheduled_jobs SET run_at = $1 WHERE name = $2 AND run_at <= $3", now.Add(interval), name, now)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestReconcileFiles(t *testing.T) {
+	files := newMemoryStore()
+	stores := objectStores{files: files, avatars: newMemoryStore()}
+	srv, db := newTestServerWith(t, stores, uploadPolicy{maxSize: 1 << 20})
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := c.UploadTaskFile(ctx, task.ID, "kept.txt", strings.NewReader("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost, err := c.UploadTaskFile(ctx, task.ID, "lost.txt", strings.NewReader("lost!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keptKey, lostKey string
+	if err := db.Get(&keptKey, "SELECT object_name FROM files WHERE id = $1", kept.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&lostKey, "SELECT object_name FROM files WHERE id = $1", lost.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пропавший объект, старый объект без записи, свежий объект без записи
+	// (его загрузка ещё может закончиться) и неверный счётчик ссылок
+	if err := files.Delete(ctx, lostKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"orphan.txt", "fresh.txt"} {
+		if err := files.Put(ctx, key, strings.NewReader("x"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files.mu.Lock()
+	orphan := files.objects["orphan.txt"]
+	orphan.modTime = time.Now().Add(-2 * reconcileGrace)
+	files.objects["orphan.txt"] = orphan
+	files.mu.Unlock()
+	db.MustExec("UPDATE blobs SET refs = 5 WHERE object_name = $1", keptKey)
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, query, args...); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	checkUnchanged := func(when string) {
+		t.Helper()
+		if count("SELECT COUNT(*) FROM files") != 2 || count("SELECT COUNT(*) FROM object_deletions") != 0 || count("SELECT refs FROM blobs WHERE object_name = $1", keptKey) != 5 {
+			t.Fatalf("%s changed the database", when)
+		}
+	}
+
+	report, err := reconcileFiles(ctx, db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.MissingObjects) != 1 || report.MissingObjects[0].ID != lost.ID {
+		t.Errorf("missing objects = %+v", report.MissingObjects)
+	}
+	if strings.Join(report.OrphanObjects, ",") != "orphan.txt" {
+		t.Errorf("orphan objects = %v", report.OrphanObjects)
+	}
+	if len(report.BlobRefs) != 1 || report.BlobRefs[0].ObjectName != keptKey || report.BlobRefs[0].Refs != 5 || report.BlobRefs[0].Actual != 1 {
+		t.Errorf("blob refs = %+v", report.BlobRefs)
+	}
+	checkUnchanged("reconcile without repair")
+
+	// Пустой бакет, скорее всего, не тот бакет
+	empty := newMemoryStore()
+	emptyReport, err := reconcileFiles(ctx, db, empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emptyReport.MissingObjects) != 2 {
+		t.Fatalf("missing objects in an empty bucket = %+v", emptyReport.MissingObjects)
+	}
+	if err := repairFiles(ctx, db, empty, emptyReport); err == nil || !strings.Contains(err.Error(), "bucket is empty") {
+		t.Fatalf("repair against an empty bucket: %v", err)
+	}
+	checkUnchanged("refused repair")
+
+	if err := repairFiles(ctx, db, files, report); err != nil {
+		t.Fatal(err)
+	}
+	if count("SELECT COUNT(*) FROM files WHERE id = $1", lost.ID) != 0 || count("SELECT COUNT(*) FROM files WHERE id = $1", kept.ID) != 1 {
+		t.Error("repair did not remove only the record without an object")
+	}
+	if count("SELECT refs FROM blobs WHERE object_name = $1", keptKey) != 1 {
+		t.Error("repair did not fix the reference count")
+	}
+	if count("SELECT COUNT(*) FROM object_deletions WHERE object_key = 'orphan.txt'") != 1 {
+		t.Error("orphan object is not queued for deletion")
+	}
+	if used := count("SELECT storage_used FROM projects WHERE id = $1", project.ID); used != len("kept") {
+		t.Errorf("storage_used after repair = %d, want %d", used, len("kept"))
+	}
+
+	if _, _, err := cleanupObjects(ctx, db, stores); err != nil {
+		t.Fatal(err)
+	}
+	report, err = reconcileFiles(ctx, db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.empty() {
+		t.Errorf("reconcile after repair found %s", report)
+	}
+	if _, err := files.Stat(ctx, "fresh.txt"); err != nil {
+		t.Errorf("fresh object: %v", err)
+	}
+}
+
+// files reconcile -repair -dry-run только показывает найденное
+func TestFilesReconcileDryRun(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_DRIVER", "sqlite")
+	t.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	t.Setenv("OBJECT_STORE_DRIVER", "local")
+	t.Setenv("OBJECT_STORE_PATH", filepath.Join(dir, "objects"))
+
+	orphan := filepath.Join(dir, "objects", "files", "orphan.txt")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * reconcileGrace)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := func() int {
+		t.Helper()
+		db, err := openMigratedDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM object_deletions WHERE object_key = 'orphan.txt'"); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := filesReconcileCommand([]string{"-repair", "-dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := queued(); n != 0 {
+		t.Fatalf("dry run queued %d objects for deletion", n)
+	}
+	if err := filesReconcileCommand([]string{"-repair"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := queued(); n != 1 {
+		t.Errorf("repair queued %d objects for deletion, want 1", n)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Возобновляемая загрузка вложений по протоколу tus 1.0
+// (https://tus.io/protocols/resumable-upload) с расширениями creation,
+// termination и expiration:
+//
+//	POST   /api/v1/tasks/:id/files/resumable  создать загрузку, Upload-Length и Upload-Metadata
+//	HEAD   /api/v1/resumable/:uploadId        сколько байт уже получено
+//	PATCH  /api/v1/resumable/:uploadId        дописать байты начиная с Upload-Offset
+//	DELETE /api/v1/resumable/:uploadId        отменить загрузку
+//
+// Байты сразу уходят в хранилище загрузкой по частям. Хвост меньше minPartSize
+// лежит отдельным объектом до следующего PATCH. Тип файла проверяется по первым
+// байтам, как и при обычной загрузке. Когда получен последний байт, файл
+// прикрепляется к задаче, а его адрес возвращается в заголовке File-Location.
+
+const tusVersion = "1.0.0"
+
+// Сколько живёт незавершённая загрузка после последнего PATCH
+const resumableExpiration = 24 * time.Hour
+
+// PATCH держит загрузку, чтобы параллельный запрос не испортил части
+const resumableLease = 10 * time.Minute
+
+// Префикс хвостов незавершённых загрузок в бакете файлов
+const resumablePrefix = "resumable/"
+
+type resumableUpload struct {
+	ID          string        `db:"id"`
+	TaskID      int           `db:"task_id"`
+	Name        string        `db:"name"`
+	Length      int64         `db:"upload_length"`
+	Offset      int64         `db:"upload_offset"`
+	ObjectName  string        `db:"object_name"`
+	ContentType string        `db:"content_type"`
+	MultipartID string        `db:"multipart_id"`
+	Parts       string        `db:"parts"`
+	TailSize    int64         `db:"tail_size"`
+	UploadedBy  sql.NullInt64 `db:"uploaded_by"`
+	FileID      sql.NullInt64 `db:"file_id"`
+	Replaces    sql.NullInt64 `db:"replaces"`
+	ExpiresAt   time.Time     `db:"expires_at"`
+}
+
+const resumableColumns = "id, task_id, name, upload_length, upload_offset, object_name, content_type, multipart_id, parts, tail_size, uploaded_by, file_id, replaces, expires_at"
+
+// Хвост начинается после последней загруженной части. Начало входит в ключ,
+// поэтому новый хвост не затирает старый, пока запись в базе на него ссылается.
+func (u *resumableUpload) tailKey() string {
+	return fmt.Sprintf("%s%s/%d", resumablePrefix, u.ID, u.Offset-u.TailSize)
+}
+
+func (u *resumableUpload) completed() bool {
+	return u.FileID.Valid
+}
+
+func (u *resumableUpload) fileLocation() string {
+	return fmt.Sprintf("/api/v1/tasks/%d/files/%d", u.TaskID, u.FileID.Int64)
+}
+
+func (u *resumableUpload) parts() ([]ObjectPart, error) {
+	var parts []ObjectPart
+	err := json.Unmarshal([]byte(u.Parts), &parts)
+	return parts, err
+}
+
+// Ошибка загрузки с кодом ответа
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// Заголовок Tus-Resumable во всех ответах и проверка версии клиента
+func tusResumable() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported Tus-Resumable version"})
+			return
+		}
+		c.Next()
+	})
+}
+
+// OPTIONS /api/v1/tasks/:id/files/resumable и /api/v1/resumable/:uploadId
+func resumableOptionsHandler(uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", "creation,termination,expiration")
+		c.Header("Tus-Max-Size", strconv.FormatInt(uploads.maxSize, 10))
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// POST /api/v1/tasks/:id/files/resumable
+func resumableCreateHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length header is required"})
+			return
+		}
+
+		limit, err := taskUploadLimit(db, uploads, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		if length > limit {
+			respondFileTooLarge(c, limit)
+			return
+		}
+
+		metadata := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+		name := metadata["filename"]
+		if name == "" {
+			name = "file"
+		}
+		replaces, err := parseReplaces(metadata["replaces"])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !checkReplaces(c, db, id, replaces) {
+			return
+		}
+		if !checkQuota(c, db, uploads, id, requestUploader(c), length) {
+			return
+		}
+
+		upload := resumableUpload{ID: uuid.New().String(), Name: name, Length: length, Parts: "[]", UploadedBy: requestUploader(c), ExpiresAt: time.Now().UTC().Add(resumableExpiration)}
+		upload.TaskID, _ = strconv.Atoi(id)
+		if replaces != 0 {
+			upload.Replaces = sql.NullInt64{Int64: int64(replaces), Valid: true}
+		}
+		_, err = db.Exec("INSERT INTO resumable_uploads (id, task_id, name, upload_length, uploaded_by, replaces, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			upload.ID, upload.TaskID, upload.Name, upload.Length, upload.UploadedBy, upload.Replaces, upload.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		// Пустому файлу PATCH не нужен, прикрепляем его сразу
+		if length == 0 {
+			if err := writeUpload(c.Request.Context(), db, files, uploads, &upload, http.NoBody); err != nil {
+				respondUploadError(c, err)
+				return
+			}
+			c.Header("File-Location", upload.fileLocation())
+		}
+
+		c.Header("Location", "/api/v1/resumable/"+upload.ID)
+		c.Header("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+		c.Status(http.StatusCreated)
+	})
+}
+
+// Upload-Metadata: "filename ZG9jLnBkZg==,filetype YXBwbGljYXRpb24vcGRm"
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
+
+// Загрузка по id. Для отсутствующей — 404, для просроченной — 410.
+// При ошибке сам отвечает клиенту и возвращает false.
+func getResumableUpload(c *gin.Context, db *sqlx.DB) (resumableUpload, bool) {
+	var upload resumableUpload
+	err := db.Get(&upload, "SELECT "+resumableColumns+" FROM resumable_uploads WHERE id = $1", c.Param("uploadId"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return upload, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return upload, false
+	}
+	if !upload.completed() && upload.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+		return upload, false
+	}
+	return upload, true
+}
+
+func setUploadHeaders(c *gin.Context, upload resumableUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	if upload.completed() {
+		c.Header("File-Location", upload.fileLocation())
+	} else {
+		c.Header("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	}
+}
+
+// HEAD /api/v1/resumable/:uploadId
+func resumableHeadHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		upload, ok := getResumableUpload(c, db)
+		if !ok {
+			return
+		}
+		setUploadHeaders(c, upload)
+		c.Status(http.StatusOK)
+	})
+}
+
+// PATCH /api/v1/resumable/:uploadId
+func resumablePatchHandler(db *sqlx.DB, files ObjectStore, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required"})
+			return
+		}
+
+		// Захватываем загрузку на время запроса
+		now := time.Now().UTC()
+		res, err := db.Exec("UPDATE resumable_uploads SET locked_until = $1 WHERE id = $2 AND (locked_until IS NULL OR locked_until <= $3)", now.Add(resumableLease), c.Param("uploadId"), now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			if _, ok := getResumableUpload(c, db); ok {
+				c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written by another request"})
+			}
+			return
+		}
+		defer db.Exec("UPDATE resumable_uploads SET locked_until = NULL WHERE id = $1", c.Param("uploadId"))
+
+		upload, ok := getResumableUpload(c, db)
+		if !ok {
+			return
+		}
+		if offset != upload.Offset {
+			setUploadHeaders(c, upload)
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload-Offset must be %d", upload.Offset)})
+			return
+		}
+		if c.Request.ContentLength > upload.Length-upload.Offset {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body exceeds Upload-Length"})
+			return
+		}
+		// Повтор последнего PATCH, ответ на который потерялся
+		if upload.completed() {
+			setUploadHeaders(c, upload)
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		err = writeUpload(c.Request.Context(), db, files, uploads, &upload, io.LimitReader(c.Request.Body, upload.Length-upload.Offset))
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+
+		setUploadHeaders(c, upload)
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// DELETE /api/v1/resumable/:uploadId
+func resumableDeleteHandler(db *sqlx.DB, files ObjectStore) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		upload, ok := getResumableUpload(c, db)
+		if !ok {
+			return
+		}
+		if upload.completed() {
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload is already complete, delete the file instead"})
+			return
+		}
+
+		if err := discardUpload(c.Request.Context(), db, files, upload); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func respondUploadError(c *gin.Context, err error) {
+	var usage *quotaError
+	if errors.As(err, &usage) {
+		respondQuotaExceeded(c, usage)
+		return
+	}
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		c.JSON(uploadErr.status, gin.H{"error": uploadErr.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	fmt.Println("error: ", err.Error())
+}
+
+// Дописывает body к загрузке. Полные части отправляются в хранилище сразу,
+// остаток сохраняется хвостом. Если клиент оборвал соединение, полученные
+// байты всё равно сохраняются, и загрузку можно продолжить с нового смещения.
+func writeUpload(ctx context.Context, db *sqlx.DB, files ObjectStore, uploads uploadPolicy, upload *resumableUpload, body io.Reader) error {
+	parts, err := upload.parts()
+	if err != nil {
+		return err
+	}
+	oldTail := upload.tailKey()
+
+	var buffer bytes.Buffer
+	if upload.TailSize > 0 {
+		tail, err := files.GetRange(ctx, oldTail, 0, upload.TailSize)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(&buffer, tail)
+		tail.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Начало файла лежит в буфере, пока не загружена первая часть
+	sniff := func() error {
+		if upload.ContentType != "" || (buffer.Len() < sniffSize && upload.Offset < upload.Length) {
+			return nil
+		}
+		_, detected, _ := sniffUpload(bytes.NewReader(buffer.Bytes()))
+		if err := uploads.check(detected, upload.Name); err != nil {
+			if discardErr := discardUpload(ctx, db, files, *upload); discardErr != nil {
+				return discardErr
+			}
+			return &uploadError{status: http.StatusUnsupportedMediaType, message: err.Error()}
+		}
+		upload.ContentType = detected.String()
+		upload.ObjectName = uuid.New().String() + detected.Extension()
+		return nil
+	}
+
+	flush := func() error {
+		if upload.MultipartID == "" {
+			multipartID, err := files.CreateMultipart(ctx, upload.ObjectName, upload.ContentType)
+			if err != nil {
+				return err
+			}
+			upload.MultipartID = multipartID
+		}
+		etag, err := files.UploadPart(ctx, upload.ObjectName, upload.MultipartID, len(parts)+1, bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			return err
+		}
+		parts = append(parts, ObjectPart{Number: len(parts) + 1, ETag: etag})
+		buffer.Reset()
+		upload.TailSize = 0
+		return saveUpload(db, upload, parts)
+	}
+
+	chunk := make([]byte, 32<<10)
+	var readErr error
+	for readErr == nil {
+		var n int
+		n, readErr = body.Read(chunk)
+		buffer.Write(chunk[:n])
+		upload.Offset += int64(n)
+		upload.TailSize += int64(n)
+
+		if err := sniff(); err != nil {
+			return err
+		}
+		if upload.ContentType != "" && buffer.Len() >= minPartSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if readErr != io.EOF {
+		fmt.Println("error: resumable upload interrupted: ", upload.ID, readErr.Error())
+	}
+
+	if upload.Offset == upload.Length {
+		if err := sniff(); err != nil {
+			return err
+		}
+		if buffer.Len() > 0 || len(parts) == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err := completeUpload(ctx, db, files, uploads, upload, parts); err != nil {
+			return err
+		}
+	} else {
+		upload.ExpiresAt = time.Now().UTC().Add(resumableExpiration)
+		if buffer.Len() > 0 {
+			if err := files.Put(ctx, upload.tailKey(), &buffer, "application/octet-stream"); err != nil {
+				return err
+			}
+		}
+		if err := saveUpload(db, upload, parts); err != nil {
+			return err
+		}
+	}
+
+	// Старый хвост больше не нужен, если его место занял новый или часть
+	if upload.TailSize == 0 || upload.tailKey() != oldTail {
+		if err := files.Delete(ctx, oldTail); err != nil {
+			fmt.Println("error: ", err.Error())
+		}
+	}
+	return nil
+}
+
+func saveUpload(db *sqlx.DB, upload *resumableUpload, parts []ObjectPart) error {
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return err
+	}
+	upload.Parts = string(data)
+	_, err = db.Exec("UPDATE resumable_uploads SET upload_offset = $1, object_name = $2, content_type = $3, multipart_id = $4, parts = $5, tail_size = $6, expires_at = $7 WHERE id = $8",
+		upload.Offset, upload.ObjectName, upload.ContentType, upload.MultipartID, upload.Parts, upload.TailSize, upload.ExpiresAt, upload.ID)
+	return err
+}
+
+// Собирает объект из частей и прикрепляет его к задаче
+func completeUpload(ctx context.Context, db *sqlx.DB, files ObjectStore, uploads uploadPolicy, upload *resumableUpload, parts []ObjectPart) error {
+	if err := files.CompleteMultipart(ctx, upload.ObjectName, upload.MultipartID, parts); err != nil {
+		return err
+	}
+
+	sum, err := objectSHA256(ctx, files, upload.ObjectName)
+	if err != nil {
+		return err
+	}
+
+	file := File{TaskID: upload.TaskID, Name: upload.Name, ContentType: upload.ContentType, Size: upload.Length, ScanStatus: uploads.initialScanStatus()}
+	key := blobKey(sum, path.Ext(upload.ObjectName))
+	created, err := insertBlobFile(db, uploads, file, upload.UploadedBy, int(upload.Replaces.Int64), sum, key, func(key string) (bool, error) {
+		return copyBlob(ctx, files, upload.ObjectName, key, mimetype.Lookup(upload.ContentType))
+	})
+	if err != nil {
+		// Собранный объект уже не продолжить, загрузка отменяется целиком
+		if discardErr := discardCompletedUpload(db, *upload); discardErr != nil {
+			return discardErr
+		}
+		if err == errReplacedFileNotFound {
+			return &uploadError{status: http.StatusConflict, message: err.Error()}
+		}
+		return err
+	}
+
+	upload.FileID = sql.NullInt64{Int64: int64(created.ID), Valid: true}
+	upload.TailSize = 0
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Содержимое теперь лежит под своим адресом
+	if err := enqueueObjectDeletion(tx, filesStoreName, upload.ObjectName); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE resumable_uploads SET upload_offset = $1, tail_size = 0, file_id = $2 WHERE id = $3", upload.Offset, created.ID, upload.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func storeObjectThumbnails(ctx context.Context, files ObjectStore, key string) error {
+	body, _, err := files.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return storeThumbnails(ctx, files, key, data)
+}
+
+// Отменяет незавершённую загрузку: части, хвост и запись
+func discardUpload(ctx context.Context, db *sqlx.DB, files ObjectStore, upload resumableUpload) error {
+	if upload.MultipartID != "" {
+		if err := files.AbortMultipart(ctx, upload.ObjectName, upload.MultipartID); err != nil {
+			return err
+		}
+	}
+	tails, err := files.List(ctx, resumablePrefix+upload.ID+"/")
+	if err != nil {
+		return err
+	}
+	for _, tail := range tails {
+		if err := files.Delete(ctx, tail.Key); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec("DELETE FROM resumable_uploads WHERE id = $1", upload.ID)
+	return err
+}
+
+func discardCompletedUpload(db *sqlx.DB, upload resumableUpload) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueueObjectDeletion(tx, filesStoreName, upload.ObjectName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM resumable_uploads WHERE id = $1", upload.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Удаляет просроченные загрузки. Записи завершённых загрузок живут
+// столько же, чтобы клиент мог узнать адрес файла через HEAD.
+func expireResumableUploads(ctx context.Context, db *sqlx.DB, files ObjectStore) (int, error) {
+	now := time.Now().UTC()
+
+	var expired []resumableUpload
+	err := db.Select(&expired, "SELECT "+resumableColumns+" FROM resumable_uploads WHERE expires_at <= $1 AND (locked_until IS NULL OR locked_until <= $1) LIMIT $2", now, cleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, upload := range expired {
+		if upload.completed() {
+			_, err = db.Exec("DELETE FROM resumable_uploads WHERE id = $1", upload.ID)
+		} else {
+			err = discardUpload(ctx, db, files, upload)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Отдаёт первые n байт r, а затем обрывается, как разорванное соединение
+type interruptedReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := r.r.Read(p[:min(len(p), r.n)])
+	r.n -= n
+	return n, err
+}
+
+// Запрос tus прямо к роутеру, без сети: обработчик заканчивается до возврата
+func tusRequest(t *testing.T, handler http.Handler, method, path string, headers map[string]string, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResumableUpload(t *testing.T) {
+	files := newMemoryStore()
+	srv, db := newTestServerWith(t, objectStores{files: files, avatars: newMemoryStore()}, uploadPolicy{maxSize: 2 * minPartSize})
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	c.SetUserID(user.ID)
+	ctx := context.Background()
+	handler := srv.Config.Handler
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Часть целиком и хвост после неё
+	content := strings.Repeat("0123456789abcdef\n", (minPartSize+1000)/17)
+	length := strconv.Itoa(len(content))
+
+	rec := tusRequest(t, handler, http.MethodPost, "/api/v1/tasks/"+strconv.Itoa(task.ID)+"/files/resumable", map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": "filename bm90ZXMudHh0",
+	}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/api/v1/resumable/") || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("create headers = %v", rec.Header())
+	}
+
+	// Соединение обрывается после первой части и ещё 10 байт
+	sent := minPartSize + 10
+	tusRequest(t, handler, http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, &interruptedReader{r: strings.NewReader(content), n: sent})
+	rec = tusRequest(t, handler, http.MethodHead, location, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(sent) {
+		t.Fatalf("after interrupted PATCH: status %d, Upload-Offset %q, want %d", rec.Code, rec.Header().Get("Upload-Offset"), sent)
+	}
+
+	// Клиент думает, что не дошло ничего
+	rec = tusRequest(t, handler, http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, strings.NewReader(content))
+	if rec.Code != http.StatusConflict || rec.Header().Get("Upload-Offset") != strconv.Itoa(sent) {
+		t.Fatalf("PATCH from a stale offset: status %d, Upload-Offset %q, want 409 and %d", rec.Code, rec.Header().Get("Upload-Offset"), sent)
+	}
+
+	rec = tusRequest(t, handler, http.MethodPatch, location, map[string]string{"Upload-Offset": strconv.Itoa(sent)}, strings.NewReader(content[sent:]))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != length {
+		t.Fatalf("resumed PATCH: status %d, Upload-Offset %q: %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+	fileLocation := rec.Header().Get("File-Location")
+	fileID, err := strconv.Atoi(fileLocation[strings.LastIndex(fileLocation, "/")+1:])
+	if err != nil || !strings.HasPrefix(fileLocation, "/api/v1/tasks/"+strconv.Itoa(task.ID)+"/files/") {
+		t.Fatalf("File-Location = %q", fileLocation)
+	}
+
+	download, err := c.DownloadTaskFile(ctx, task.ID, fileID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(download.Body)
+	download.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content || download.FileName != "notes.txt" {
+		t.Errorf("downloaded %d bytes as %q, want %d bytes as notes.txt", len(got), download.FileName, len(content))
+	}
+
+	// Завершённая загрузка по-прежнему сообщает адрес файла
+	rec = tusRequest(t, handler, http.MethodHead, location, nil, nil)
+	if rec.Header().Get("File-Location") != fileLocation {
+		t.Errorf("HEAD of a completed upload: File-Location %q", rec.Header().Get("File-Location"))
+	}
+
+	// Брошенная загрузка с хвостом
+	rec = tusRequest(t, handler, http.MethodPost, "/api/v1/tasks/"+strconv.Itoa(task.ID)+"/files/resumable", map[string]string{"Upload-Length": "100"}, nil)
+	abandoned := rec.Header().Get("Location")
+	rec = tusRequest(t, handler, http.MethodPatch, abandoned, map[string]string{"Upload-Offset": "0"}, strings.NewReader("hello"))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PATCH of the abandoned upload: status %d: %s", rec.Code, rec.Body)
+	}
+
+	db.MustExec("UPDATE resumable_uploads SET expires_at = $1", time.Now().UTC().Add(-time.Minute))
+	rec = tusRequest(t, handler, http.MethodHead, abandoned, nil, nil)
+	if rec.Code != http.StatusGone {
+		t.Errorf("HEAD of an expired upload: status %d, want 410", rec.Code)
+	}
+
+	expired, err := expireResumableUploads(ctx, db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 2 {
+		t.Errorf("expired %d uploads, want 2", expired)
+	}
+	for _, path := range []string{location, abandoned} {
+		if rec := tusRequest(t, handler, http.MethodHead, path, nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("HEAD %s after expiry: status %d, want 404", path, rec.Code)
+		}
+	}
+	tails, err := files.List(ctx, resumablePrefix)
+	if err != nil || len(tails) != 0 {
+		t.Errorf("tails after expiry = %+v, %v", tails, err)
+	}
+	download, err = c.DownloadTaskFile(ctx, task.ID, fileID, "")
+	if err != nil {
+		t.Fatalf("file of an expired completed upload: %v", err)
+	}
+	download.Body.Close()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Проверка вложений на вирусы. Пока файл не проверен, он в карантине
+// (scan_status = pending) и скачать его нельзя. Фоновый обработчик читает
+// файл из хранилища и передаёт сканеру: чистый файл становится доступным,
+// заражённый удаляется, а загрузивший его пользователь получает уведомление.
+
+// Состояния files.scan_status
+const (
+	scanPending = "pending"
+	scanClean   = "clean"
+)
+
+const (
+	scanInterval = 5 * time.Second
+	scanBatch    = 20
+	scanLease    = 10 * time.Minute
+)
+
+type ScanResult struct {
+	Infected bool
+	// Название угрозы от сканера, например Eicar-Signature
+	Threat string
+}
+
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (ScanResult, error)
+}
+
+// Сканер выбирается через SCANNER_DRIVER:
+//   - пусто — проверки нет, файлы доступны сразу;
+//   - clamd — демон ClamAV по адресу CLAMD_ADDRESS, например
+//     tcp://localhost:3310 или unix:///var/run/clamav/clamd.ctl;
+//   - fake — считает заражёнными файлы с тестовой строкой EICAR, для разработки.
+func newScanner() (Scanner, error) {
+	switch driver := os.Getenv("SCANNER_DRIVER"); driver {
+	case "":
+		return nil, nil
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "tcp://localhost:3310"
+		}
+		return newClamdScanner(address)
+	case "fake":
+		return fakeScanner{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SCANNER_DRIVER %q", driver)
+	}
+}
+
+// Клиент clamd по протоколу INSTREAM
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// Размер куска INSTREAM, должен быть меньше StreamMaxLength в clamd.conf
+const clamdChunkSize = 64 << 10
+
+func newClamdScanner(address string) (*clamdScanner, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("CLAMD_ADDRESS: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		return &clamdScanner{network: "tcp", address: u.Host, timeout: 2 * time.Minute}, nil
+	case "unix":
+		return &clamdScanner{network: "unix", address: u.Path, timeout: 2 * time.Minute}, nil
+	default:
+		return nil, fmt.Errorf("CLAMD_ADDRESS: unsupported scheme %q", u.Scheme)
+	}
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, content io.Reader) (ScanResult, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+
+	// Каждый кусок предваряется длиной в 4 байта, кусок нулевой длины завершает поток
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := content.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return ScanResult{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// Ответы clamd: "stream: OK", "stream: Eicar-Signature FOUND",
+// "INSTREAM size limit exceeded. ERROR"
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// Тестовая строка EICAR, её распознают все антивирусы
+const eicarSignature = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(ctx context.Context, content io.Reader) (ScanResult, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	if bytes.Contains(data, []byte(eicarSignature)) {
+		return ScanResult{Infected: true, Threat: "Eicar-Signature"}, nil
+	}
+	return ScanResult{}, nil
+}
+
+type pendingScan struct {
+	ID         int           `db:"id"`
+	TaskID     int           `db:"task_id"`
+	Name       string        `db:"name"`
+	ObjectName string        `db:"object_name"`
+	UploadedBy sql.NullInt64 `db:"uploaded_by"`
+	Attempts   int           `db:"scan_attempts"`
+}
+
+// Фоновый обработчик карантина, работает до отмены ctx
+func runScanner(ctx context.Context, db *sqlx.DB, files ObjectStore, scanner Scanner) {
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, _, err := scanFiles(ctx, db, files, scanner); err != nil {
+			fmt.Println("error: scan: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Проверяет файлы из карантина. Возвращает число проверенных файлов
+// и число заражённых среди них.
+func scanFiles(ctx context.Context, db *sqlx.DB, files ObjectStore, scanner Scanner) (int, int, error) {
+	now := time.Now().UTC()
+
+	var pending []pendingScan
+	err := db.Select(&pending, "SELECT id, task_id, name, object_name, uploaded_by, scan_attempts FROM files WHERE scan_status = $1 AND (scan_after IS NULL OR scan_after <= $2) ORDER BY id LIMIT $3", scanPending, now, scanBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	scanned, infected := 0, 0
+	for _, file := range pending {
+		// Захватываем файл, чтобы его не проверял другой экземпляр сервера
+		res, err := db.Exec("UPDATE files SET scan_after = $1 WHERE id = $2 AND scan_status = $3 AND (scan_after IS NULL OR scan_after <= $4)", now.Add(scanLease), file.ID, scanPending, now)
+		if err != nil {
+			return scanned, infected, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		result, scanErr := scanObject(ctx, files, scanner, file.ObjectName)
+		if scanErr != nil {
+			fmt.Println("error: scan: ", file.ObjectName, scanErr.Error())
+			_, err := db.Exec("UPDATE files SET scan_attempts = scan_attempts + 1, scan_error = $1, scan_after = $2 WHERE id = $3",
+				scanErr.Error(), time.Now().UTC().Add(cleanupBackoff(file.Attempts+1)), file.ID)
+			if err != nil {
+				return scanned, infected, err
+			}
+			continue
+		}
+		scanned++
+
+		if !result.Infected {
+			if _, err := db.Exec("UPDATE files SET scan_status = $1, scan_error = '' WHERE id = $2", scanClean, file.ID); err != nil {
+				return scanned, infected, err
+			}
+			continue
+		}
+
+		infected++
+		if err := removeInfectedFile(db, file, result.Threat); err != nil {
+			return scanned, infected, err
+		}
+	}
+
+	return scanned, infected, nil
+}
+
+func scanObject(ctx context.Context, files ObjectStore, scanner Scanner, key string) (ScanResult, error) {
+	body, _, err := files.Get(ctx, key)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer body.Close()
+	return scanner.Scan(ctx, body)
+}
+
+// Удаляет заражённый файл и сообщает об этом тому, кто его загрузил
+func removeInfectedFile(db *sqlx.DB, file pendingScan, threat string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := deleteFiles(tx, "SELECT id FROM files WHERE id = $1", file.ID); err != nil {
+		return err
+	}
+	if file.UploadedBy.Valid {
+		message := fmt.Sprintf("File %q attached to task %d was removed: the virus scanner found %s", file.Name, file.TaskID, threat)
+		if err := notifyUser(tx, file.UploadedBy.Int64, message); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("scan: removed infected file without uploader: ", file.ObjectName, threat)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+// Файл в карантине не скачивается, заражённый удаляется вместе с объектом,
+// а загрузивший его получает уведомление
+func TestScanQuarantine(t *testing.T) {
+	files := newMemoryStore()
+	stores := objectStores{files: files, avatars: newMemoryStore()}
+	srv, db := newTestServerWith(t, stores, uploadPolicy{maxSize: 1 << 20, quarantine: true})
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	c.SetUserID(user.ID)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infected, err := c.UploadTaskFile(ctx, task.ID, "eicar.txt", strings.NewReader(eicarSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infected.ScanStatus != scanPending || clean.ScanStatus != scanPending {
+		t.Fatalf("scan status after upload: %q and %q, want pending", infected.ScanStatus, clean.ScanStatus)
+	}
+
+	for _, file := range []api.File{infected, clean} {
+		_, err := c.DownloadTaskFile(ctx, task.ID, file.ID, "")
+		if apiErr, ok := err.(*client.Error); !ok || apiErr.StatusCode != http.StatusConflict {
+			t.Errorf("download of pending %s: %v, want 409", file.Name, err)
+		}
+	}
+
+	var key string
+	if err := db.Get(&key, "SELECT object_name FROM files WHERE id = $1", infected.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.Stat(ctx, key); err != nil {
+		t.Fatalf("uploaded object: %v", err)
+	}
+
+	scanned, found, err := scanFiles(ctx, db, files, fakeScanner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != 2 || found != 1 {
+		t.Fatalf("scanned %d, infected %d, want 2 and 1", scanned, found)
+	}
+
+	download, err := c.DownloadTaskFile(ctx, task.ID, clean.ID, "")
+	if err != nil {
+		t.Fatalf("download of a clean file: %v", err)
+	}
+	download.Body.Close()
+	if _, err := c.DownloadTaskFile(ctx, task.ID, infected.ID, ""); !client.IsNotFound(err) {
+		t.Errorf("download of an infected file: %v, want 404", err)
+	}
+
+	if _, _, err := cleanupObjects(ctx, db, stores); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.Stat(ctx, key); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("infected object: %v, want errObjectNotFound", err)
+	}
+
+	notifications, err := c.ListNotifications(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || !strings.Contains(notifications[0].Message, "eicar.txt") || !strings.Contains(notifications[0].Message, "Eicar-Signature") {
+		t.Errorf("notifications = %+v", notifications)
+	}
+}
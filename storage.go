@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Открывает базу, выбранную через DATABASE_DRIVER: postgres (по умолчанию) или sqlite.
+// Для sqlite используется файл из DATABASE_PATH, внешний сервер не нужен.
+func openDatabase() (*sqlx.DB, error) {
+	switch driver := os.Getenv("DATABASE_DRIVER"); driver {
+	case "", "postgres":
+		databaseHost := os.Getenv("DATABASE_HOST")
+		databaseUser := os.Getenv("DATABASE_USER")
+		databasePassword := os.Getenv("DATABASE_PASSWORD")
+		databasePort := "5432"
+		databaseName := os.Getenv("DATABASE_NAME")
+
+		// databaseHost := "localhost"
+		// databaseUser := "postgres"
+		// databasePort := "5433"
+		// databasePassword := "0921"
+		// databaseName := "postgres"
+
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", databaseHost, databasePort, databaseUser, databasePassword, databaseName)
+		return sqlx.Connect("postgres", dsn)
+	case "sqlite":
+		path := os.Getenv("DATABASE_PATH")
+		if path == "" {
+			path = "justontime.db"
+		}
+		return openSQLite(path)
+	default:
+		return nil, fmt.Errorf("unknown DATABASE_DRIVER %q", driver)
+	}
+}
+
+func openSQLite(path string) (*sqlx.DB, error) {
+	// _txlock=immediate берёт блокировку на запись в начале транзакции,
+	// это заменяет SELECT ... FOR UPDATE из Postgres
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	return sqlx.Connect("sqlite", dsn)
+}
+
+func isSQLite(db sqlx.Ext) bool {
+	return db.DriverName() == "sqlite"
+}
+
+// Суффикс для блокировки строки в транзакции
+func forUpdate(db sqlx.Ext) string {
+	if isSQLite(db) {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
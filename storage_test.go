@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// База SQLite во временном каталоге со всеми миграциями
+func newTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := openSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Полный роутер поверх базы SQLite и хранилищ в памяти
+func newTestServer(t *testing.T) (*httptest.Server, *sqlx.DB) {
+	t.Helper()
+	return newTestServerWith(t, objectStores{files: newMemoryStore(), avatars: newMemoryStore()}, uploadPolicy{maxSize: 1 << 20})
+}
+
+// То же со своими хранилищами и ограничениями на загрузки
+func newTestServerWith(t *testing.T, stores objectStores, uploads uploadPolicy) (*httptest.Server, *sqlx.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	srv := httptest.NewServer(setupRouter(db, stores, uploads))
+	t.Cleanup(srv.Close)
+	return srv, db
+}
+
+// Отправляет запрос с телом body в JSON и разбирает ответ в out, если он не nil
+func doJSON(t *testing.T, srv *httptest.Server, method, path string, body interface{}, headers map[string]string, out interface{}) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db := newTestDB(t)
+
+	var version int
+	if err := db.Get(&version, "SELECT MAX(version) FROM schema_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("schema version = %d, want %d", version, len(migrations))
+	}
+
+	applied, err := migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("second migrate applied %v", applied)
+	}
+}
+
+func TestSQLiteHandlers(t *testing.T) {
+	srv, db := newTestServer(t)
+
+	var user User
+	resp := doJSON(t, srv, http.MethodPost, "/api/v1/users", User{Name: "Ann", Login: "ann", Password: "secret"}, nil, &user)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create user: status %d", resp.StatusCode)
+	}
+
+	var project Project
+	resp = doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board", Logins: []string{"ann"}}, nil, &project)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create project: status %d", resp.StatusCode)
+	}
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+
+	for _, name := range []string{"To Do", "Done"} {
+		resp = doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: name}, nil, nil)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create column %q: status %d", name, resp.StatusCode)
+		}
+	}
+
+	var task TaskResponse
+	resp = doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Write tests", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID}, nil, &task)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create task: status %d", resp.StatusCode)
+	}
+	taskPath := "/api/v1/tasks/" + strconv.Itoa(task.ID)
+
+	// Обновление по версии идёт через транзакцию с блокировкой строки
+	var patched TaskResponse
+	resp = doJSON(t, srv, http.MethodPatch, taskPath, map[string]string{"status": "Done"}, map[string]string{"If-Match": etag(task.Version)}, &patched)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch task: status %d", resp.StatusCode)
+	}
+	if patched.Status != "Done" || patched.Version != task.Version+1 {
+		t.Fatalf("patched task = %+v", patched)
+	}
+
+	resp = doJSON(t, srv, http.MethodPatch, taskPath, map[string]string{"status": "To Do"}, map[string]string{"If-Match": etag(task.Version)}, nil)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("patch with stale version: status %d, want 412", resp.StatusCode)
+	}
+
+	resp = doJSON(t, srv, http.MethodDelete, taskPath, nil, nil, nil)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete task: status %d", resp.StatusCode)
+	}
+	var left int
+	if err := db.Get(&left, "SELECT COUNT(*) FROM tasks WHERE id = $1", task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatal("task is still in the database after delete")
+	}
+}
+
+// Одновременные изменения одной строки в SQLite не теряются и не падают с
+// "database is locked": транзакции берут блокировку на запись сразу
+func TestSQLiteConcurrentUpdates(t *testing.T) {
+	srv, db := newTestServer(t)
+
+	var project Project
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board"}, nil, &project)
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+
+	const writers = 8
+	var wg sync.WaitGroup
+	statuses := make([]int, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := json.Marshal(Column{Name: "Column " + strconv.Itoa(i)})
+			resp, err := http.Post(srv.URL+projectPath+"/columns", "application/json", bytes.NewReader(req))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusCreated {
+			t.Errorf("writer %d: status %d", i, status)
+		}
+	}
+	var version int
+	if err := db.Get(&version, "SELECT version FROM projects WHERE id = $1", project.ID); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1+writers {
+		t.Errorf("project version = %d, want %d", version, 1+writers)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Поля задачи, которые можно менять через PATCH. Значение — можно ли сбросить поле в null.
+// Колонку задают либо status (имя), либо column_id: второе поле подставляется по первому.
+var patchableTaskFields = map[string]bool{
+	"name":      false,
+	"descr":     true,
+	"date":      false,
+	"date_act":  true,
+	"status":    false,
+	"column_id": false,
+	"priority":  true,
+	"empl_id":   true,
+}
+
+// Ошибка изменения задачи с HTTP статусом для ответа
+type taskPatchError struct {
+	status  int
+	message string
+	// Текущее состояние задачи при конфликте версий
+	current interface{}
+}
+
+func (e *taskPatchError) Error() string {
+	return e.message
+}
+
+func badTaskPatch(format string, args ...interface{}) error {
+	return &taskPatchError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// Формат date и date_act задачи
+const taskDateLayout = "2006-01-02"
+
+func validateTaskDate(field string, value string) error {
+	if _, err := time.Parse(taskDateLayout, value); err != nil {
+		return badTaskPatch("%s must be a date like 2024-01-31", field)
+	}
+	return nil
+}
+
+// Текущие значения изменяемых полей задачи
+func taskDocument(task Task) map[string]*string {
+	nullable := func(ns sql.NullString) *string {
+		if !ns.Valid {
+			return nil
+		}
+		return &ns.String
+	}
+	name, date, status, columnID := task.Name, task.Date, task.Status, strconv.Itoa(task.Column_id)
+	return map[string]*string{
+		"name":      &name,
+		"descr":     nullable(task.Descr),
+		"date":      &date,
+		"date_act":  nullable(task.Date_act),
+		"status":    &status,
+		"column_id": &columnID,
+		"priority":  nullable(task.Priority),
+		"empl_id":   nullable(task.Empl_id),
+	}
+}
+
+// Переводит JSON значение поля в строку, null — в nil
+func patchValue(field string, raw json.RawMessage) (*string, error) {
+	nullable, ok := patchableTaskFields[field]
+	if !ok {
+		return nil, badTaskPatch("Field %q cannot be changed", field)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, badTaskPatch("Invalid value for %q", field)
+	}
+
+	switch v := value.(type) {
+	case nil:
+		if !nullable {
+			return nil, badTaskPatch("Field %q cannot be null", field)
+		}
+		return nil, nil
+	case string:
+		return &v, nil
+	case float64:
+		if field == "empl_id" || field == "column_id" {
+			s := strconv.FormatFloat(v, 'f', -1, 64)
+			return &s, nil
+		}
+	}
+	return nil, badTaskPatch("Field %q must be a string", field)
+}
+
+// RFC 7386: присутствующие поля заменяются, null удаляет значение
+func applyMergePatch(doc map[string]*string, body []byte) error {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return badTaskPatch("Merge patch must be a JSON object")
+	}
+
+	for field, raw := range patch {
+		value, err := patchValue(field, raw)
+		if err != nil {
+			return err
+		}
+		doc[field] = value
+	}
+	return nil
+}
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// RFC 6902 для плоского документа задачи: add, replace, remove и test
+func applyJSONPatch(doc map[string]*string, body []byte) error {
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return badTaskPatch("JSON patch must be an array of operations")
+	}
+
+	for i, operation := range operations {
+		field := strings.TrimPrefix(operation.Path, "/")
+		if !strings.HasPrefix(operation.Path, "/") || strings.Contains(field, "/") {
+			return badTaskPatch("Operation %d: invalid path %q", i, operation.Path)
+		}
+
+		switch operation.Op {
+		case "add", "replace":
+			value, err := patchValue(field, operation.Value)
+			if err != nil {
+				return err
+			}
+			doc[field] = value
+		case "remove":
+			if _, err := patchValue(field, json.RawMessage("null")); err != nil {
+				return err
+			}
+			doc[field] = nil
+		case "test":
+			value, err := patchValue(field, operation.Value)
+			if err != nil {
+				return err
+			}
+			current := doc[field]
+			if (current == nil) != (value == nil) || (current != nil && *current != *value) {
+				return &taskPatchError{status: http.StatusConflict, message: fmt.Sprintf("Operation %d: test failed for %q", i, operation.Path)}
+			}
+		default:
+			return badTaskPatch("Operation %d: unsupported op %q", i, operation.Op)
+		}
+	}
+	return nil
+}
+
+// Применяет изменения к задаче в одной транзакции: проверяет новые значения,
+// обновляет строку и пишет одну запись в историю.
+// ifMatch — значение заголовка If-Match, при несовпадении версии возвращается 412.
+func updateTask(db *sqlx.DB, id string, ifMatch string, apply func(doc map[string]*string) error) (TaskResponse, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return TaskResponse{}, err
+	}
+	defer tx.Rollback()
+
+	var task Task
+	err = tx.Get(&task, "SELECT "+taskFields+" FROM tasks WHERE id = $1"+forUpdate(tx), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return TaskResponse{}, &taskPatchError{status: http.StatusNotFound, message: "Task not found"}
+		}
+		return TaskResponse{}, err
+	}
+
+	if !ifMatchAllows(ifMatch, task.Version) {
+		return TaskResponse{}, &taskPatchError{status: http.StatusPreconditionFailed, message: "Task was modified by someone else", current: newTaskResponse(task)}
+	}
+
+	current := taskDocument(task)
+	doc := make(map[string]*string, len(current))
+	for field, value := range current {
+		doc[field] = value
+	}
+	if err := apply(doc); err != nil {
+		return TaskResponse{}, err
+	}
+
+	changes := make(map[string]TaskChange)
+	for field, value := range doc {
+		before := current[field]
+		if (before == nil) != (value == nil) || (before != nil && *before != *value) {
+			changes[field] = TaskChange{From: before, To: value}
+		}
+	}
+	if len(changes) == 0 {
+		return newTaskResponse(task), nil
+	}
+
+	if err := validateTaskChanges(tx, task.Project_id, current, changes); err != nil {
+		return TaskResponse{}, err
+	}
+
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var sets []string
+	var args []interface{}
+	for _, field := range fields {
+		args = append(args, changes[field].To)
+		sets = append(sets, fmt.Sprintf("%s = $%d", field, len(args)))
+	}
+	sets = append(sets, "version = version + 1")
+	args = append(args, id)
+
+	var updated Task
+	err = tx.Get(&updated, fmt.Sprintf("UPDATE tasks SET %s WHERE id = $%d RETURNING "+taskFields, strings.Join(sets, ", "), len(args)), args...)
+	if err != nil {
+		return TaskResponse{}, err
+	}
+
+	history, err := json.Marshal(changes)
+	if err != nil {
+		return TaskResponse{}, err
+	}
+	_, err = tx.Exec("INSERT INTO task_history (task_id, changes) VALUES ($1, $2)", updated.ID, string(history))
+	if err != nil {
+		return TaskResponse{}, err
+	}
+
+	return newTaskResponse(updated), tx.Commit()
+}
+
+// Проверяет новые значения полей. Изменение status или column_id дополняется
+// изменением второго поля, чтобы они указывали на одну колонку.
+func validateTaskChanges(tx *sqlx.Tx, projectID int, current map[string]*string, changes map[string]TaskChange) error {
+	if change, ok := changes["name"]; ok && strings.TrimSpace(*change.To) == "" {
+		return badTaskPatch("Task name cannot be empty")
+	}
+	if change, ok := changes["date"]; ok {
+		if strings.TrimSpace(*change.To) == "" {
+			return badTaskPatch("Task date cannot be empty")
+		}
+		if err := validateTaskDate("date", *change.To); err != nil {
+			return err
+		}
+	}
+	if change, ok := changes["date_act"]; ok && change.To != nil {
+		if err := validateTaskDate("date_act", *change.To); err != nil {
+			return err
+		}
+	}
+
+	var column Column
+	if change, ok := changes["column_id"]; ok {
+		id, err := strconv.Atoi(*change.To)
+		if err != nil {
+			return badTaskPatch("column_id must be a column id")
+		}
+		column, err = findColumnRef(tx, projectID, columnRef{id: id})
+		if err == sql.ErrNoRows {
+			return badTaskPatch("Column %d does not exist in the project", id)
+		}
+		if err != nil {
+			return err
+		}
+		if status, ok := changes["status"]; ok && *status.To != column.Name {
+			return badTaskPatch("Status %q does not match column %d", *status.To, id)
+		}
+	} else if change, ok := changes["status"]; ok {
+		var err error
+		column, err = findColumn(tx, projectID, *change.To)
+		if err == sql.ErrNoRows {
+			return badTaskPatch("Column %q does not exist in the project", *change.To)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if column.ID != 0 {
+		columnID := strconv.Itoa(column.ID)
+		if *current["status"] != column.Name {
+			changes["status"] = TaskChange{From: current["status"], To: &column.Name}
+		}
+		if *current["column_id"] != columnID {
+			changes["column_id"] = TaskChange{From: current["column_id"], To: &columnID}
+		}
+	}
+
+	if change, ok := changes["empl_id"]; ok && change.To != nil {
+		var count int
+		err := tx.Get(&count, "SELECT COUNT(*) FROM user_projects WHERE CAST(user_id AS TEXT) = $1 AND project_id = $2", *change.To, projectID)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return badTaskPatch("Assignee is not a member of the project")
+		}
+	}
+
+	return nil
+}
+
+func respondTaskPatchError(c *gin.Context, err error) {
+	if patchErr, ok := err.(*taskPatchError); ok {
+		if patchErr.current != nil {
+			c.JSON(patchErr.status, gin.H{"error": patchErr.message, "current": patchErr.current})
+			return
+		}
+		c.JSON(patchErr.status, gin.H{"error": patchErr.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	fmt.Println("error: ", err.Error())
+}
+
+// Устанавливает поля задачи, как в одном merge patch
+func setTaskFields(values map[string]*string) func(doc map[string]*string) error {
+	return func(doc map[string]*string) error {
+		for field, value := range values {
+			doc[field] = value
+		}
+		return nil
+	}
+}
+
+// PATCH /api/v1/tasks/:id
+// application/merge-patch+json (RFC 7386) или application/json-patch+json (RFC 6902)
+func taskPatchHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var apply func(doc map[string]*string) error
+		switch c.ContentType() {
+		case "application/json-patch+json":
+			apply = func(doc map[string]*string) error { return applyJSONPatch(doc, body) }
+		case "application/merge-patch+json", "application/json":
+			apply = func(doc map[string]*string) error { return applyMergePatch(doc, body) }
+		default:
+			c.Header("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported patch format"})
+			return
+		}
+
+		task, err := updateTask(db, id, c.GetHeader("If-Match"), apply)
+		if err != nil {
+			respondTaskPatchError(c, err)
+			return
+		}
+
+		c.Header("ETag", etag(task.Version))
+		c.JSON(http.StatusOK, task)
+	})
+}
+
+// GET /api/v1/tasks/:id/history
+func taskHistoryHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.Param("id")
+
+		rows, err := db.Query("SELECT id, task_id, changed_at, changes FROM task_history WHERE task_id = $1 ORDER BY id", id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		history := []TaskHistoryEntry{}
+		for rows.Next() {
+			var entry TaskHistoryEntry
+			var changes []byte
+			if err := rows.Scan(&entry.ID, &entry.TaskID, &entry.ChangedAt, &changes); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			history = append(history, entry)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"history": history})
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestTaskDateValidation(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	var project Project
+	doJSON(t, srv, http.MethodPost, "/api/v1/projects", NewProject{Name: "Board"}, nil, &project)
+	projectPath := "/api/v1/projects/" + strconv.Itoa(project.ID)
+	doJSON(t, srv, http.MethodPost, projectPath+"/columns", Column{Name: "To Do"}, nil, nil)
+
+	resp := doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Ship", Date: "next week", Status: "To Do"}, nil, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("create with a bad date: status %d, want 400", resp.StatusCode)
+	}
+
+	var task TaskResponse
+	resp = doJSON(t, srv, http.MethodPost, projectPath+"/tasks", Task{Name: "Ship", Date: "2024-01-31", Status: "To Do"}, nil, &task)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create task: status %d", resp.StatusCode)
+	}
+	taskPath := "/api/v1/tasks/" + strconv.Itoa(task.ID)
+
+	for name, body := range map[string]interface{}{
+		"merge patch date":     map[string]string{"date": "tomorrow-ish"},
+		"merge patch date_act": map[string]string{"date_act": "2024-02-30"},
+	} {
+		resp = doJSON(t, srv, http.MethodPatch, taskPath, body, nil, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, resp.StatusCode)
+		}
+	}
+
+	resp = doJSON(t, srv, http.MethodPatch, taskPath, []jsonPatchOperation{{Op: "replace", Path: "/date", Value: []byte(`"soon"`)}},
+		map[string]string{"Content-Type": "application/json-patch+json"}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("JSON patch date: status %d, want 400", resp.StatusCode)
+	}
+
+	var patched TaskResponse
+	resp = doJSON(t, srv, http.MethodPatch, taskPath, map[string]interface{}{"date": "2024-02-29", "date_act": nil}, nil, &patched)
+	if resp.StatusCode != http.StatusOK || patched.Date != "2024-02-29" {
+		t.Errorf("valid date: status %d, task %+v", resp.StatusCode, patched)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // декодеры для image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Миниатюры картинок из вложений. Строятся при загрузке для PNG, JPEG и GIF
+// (у GIF берётся первый кадр) и хранятся в том же бакете под ключами
+// thumbnails/<размер>/<ключ оригинала>.jpg. Размер — наибольшая сторона
+// в пикселях, картинки меньше этого размера не увеличиваются.
+
+// По возрастанию: storeThumbnails строит каждую миниатюру из следующей, большей
+var thumbnailSizes = []int{64, 256, 1024}
+
+const thumbnailPrefix = "thumbnails/"
+
+// Картинки больше этого числа пикселей не разжимаем, чтобы маленький файл
+// не занял гигабайты памяти
+const thumbnailMaxPixels = 50_000_000
+
+func thumbnailKey(objectName string, size int) string {
+	return fmt.Sprintf("%s%d/%s.jpg", thumbnailPrefix, size, objectName)
+}
+
+// Ключ оригинала по ключу миниатюры
+func thumbnailSource(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, thumbnailPrefix)
+	if !ok {
+		return "", false
+	}
+	_, objectName, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(objectName, ".jpg")
+}
+
+// Ссылки на миниатюры для ответа API. Пока файл не проверен на вирусы,
+// миниатюры не показываем. Миниатюры доступны только участникам проекта,
+// как и сам файл: ссылку нужно запрашивать с заголовком X-User-ID, поэтому
+// напрямую в <img src> она не годится.
+func thumbnailURLs(file File, hasThumbnails bool) map[string]string {
+	if !hasThumbnails || file.ScanStatus != scanClean {
+		return nil
+	}
+	urls := make(map[string]string, len(thumbnailSizes))
+	for _, size := range thumbnailSizes {
+		urls[strconv.Itoa(size)] = fmt.Sprintf("/api/v1/tasks/%d/files/%d/thumbnails/%d", file.TaskID, file.ID, size)
+	}
+	return urls
+}
+
+func thumbnailSourceType(detected *mimetype.MIME) bool {
+	return detected.Is("image/png") || detected.Is("image/jpeg") || detected.Is("image/gif")
+}
+
+// Строит миниатюры и кладёт их в хранилище. Ошибка не мешает загрузке
+// самого файла, поэтому только возвращается вызывающему для лога.
+func storeThumbnails(ctx context.Context, files ObjectStore, objectName string, data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return fmt.Errorf("image is too large for thumbnails: %dx%d", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// Каждый размер уменьшаем из предыдущего, большего: исходная картинка
+	// переводится в RGBA один раз, а следующие проходы всё короче
+	thumbnail := toRGBA(src)
+	for i := len(thumbnailSizes) - 1; i >= 0; i-- {
+		size := thumbnailSizes[i]
+		thumbnail = resizeImage(thumbnail, size)
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: 85}); err != nil {
+			return err
+		}
+		if err := files.Put(ctx, thumbnailKey(objectName, size), &out, "image/jpeg"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Копия картинки в RGBA с началом координат в (0, 0)
+func toRGBA(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	return rgba
+}
+
+// Уменьшает картинку так, чтобы большая сторона была не больше size.
+// Каждый пиксель результата — среднее пикселей исходной картинки, которые
+// он покрывает. Прозрачные места заливаются белым, JPEG прозрачность не хранит.
+// rgba должна начинаться в (0, 0), см. toRGBA.
+func resizeImage(rgba *image.RGBA, size int) *image.RGBA {
+	srcW, srcH := rgba.Rect.Dx(), rgba.Rect.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[offset])
+					g += int(rgba.Pix[offset+1])
+					b += int(rgba.Pix[offset+2])
+					a += int(rgba.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			// Цвета в image.RGBA уже умножены на альфу, белый фон добавляется как 255 - a
+			offset := dst.PixOffset(x, y)
+			white := 255 - a/n
+			dst.Pix[offset] = uint8(r/n + white)
+			dst.Pix[offset+1] = uint8(g/n + white)
+			dst.Pix[offset+2] = uint8(b/n + white)
+			dst.Pix[offset+3] = 255
+		}
+	}
+	return dst
+}
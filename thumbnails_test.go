@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestResizeImage(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, size int
+		wantW, wantH        int
+	}{
+		{2000, 1000, 256, 256, 128},
+		{1000, 2000, 256, 128, 256},
+		{100, 50, 256, 100, 50},
+		{3000, 1, 64, 64, 1},
+	} {
+		got := resizeImage(image.NewRGBA(image.Rect(0, 0, tc.width, tc.height)), tc.size)
+		if got.Rect.Dx() != tc.wantW || got.Rect.Dy() != tc.wantH {
+			t.Errorf("resize %dx%d to %d: %dx%d, want %dx%d", tc.width, tc.height, tc.size, got.Rect.Dx(), got.Rect.Dy(), tc.wantW, tc.wantH)
+		}
+	}
+
+	// Прозрачный фон становится белым, непрозрачные пиксели усредняются
+	src := image.NewNRGBA(image.Rect(10, 10, 14, 12))
+	src.Set(10, 10, color.NRGBA{R: 255, A: 255})
+	src.Set(11, 10, color.NRGBA{B: 255, A: 255})
+	src.Set(10, 11, color.NRGBA{R: 255, A: 255})
+	src.Set(11, 11, color.NRGBA{B: 255, A: 255})
+	got := resizeImage(toRGBA(src), 2)
+	if left, right := got.RGBAAt(0, 0), got.RGBAAt(1, 0); left != (color.RGBA{R: 127, B: 127, A: 255}) || right != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("resized pixels = %v, %v", left, right)
+	}
+}
+
+// Миниатюры строятся только для картинок, а скачать их, как и сам файл,
+// можно только с X-User-ID
+func TestTaskFileThumbnails(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	user, project := newTestBoard(t, c)
+	c.SetUserID(user.ID)
+	ctx := context.Background()
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+	file, err := c.UploadTaskFile(ctx, task.ID, "picture.png", &picture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Thumbnails) != len(thumbnailSizes) {
+		t.Fatalf("thumbnails = %v", file.Thumbnails)
+	}
+
+	for _, size := range thumbnailSizes {
+		path := file.Thumbnails[strconv.Itoa(size)]
+		if want := fmt.Sprintf("/api/v1/tasks/%d/files/%d/thumbnails/%d", task.ID, file.ID, size); path != want {
+			t.Errorf("thumbnail %d = %q, want %q", size, path, want)
+		}
+
+		resp := doJSON(t, srv, http.MethodGet, path, nil, nil, nil)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("thumbnail %d without X-User-ID: status %d, want 401", size, resp.StatusCode)
+		}
+
+		download, err := c.DownloadThumbnail(ctx, task.ID, file.ID, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := jpeg.DecodeConfig(download.Body)
+		download.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantW, wantH := min(600, size), min(300, size/2)
+		if config.Width != wantW || config.Height != wantH {
+			t.Errorf("thumbnail %d is %dx%d, want %dx%d", size, config.Width, config.Height, wantW, wantH)
+		}
+	}
+
+	text, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text.Thumbnails != nil {
+		t.Errorf("thumbnails of a text file = %v", text.Thumbnails)
+	}
+	if _, err := c.DownloadThumbnail(ctx, task.ID, text.ID, 64); !client.IsNotFound(err) {
+		t.Errorf("thumbnail of a text file: %v, want 404", err)
+	}
+}
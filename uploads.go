@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения на вложения задач:
+//   - UPLOAD_MAX_SIZE — наибольший размер файла, например 25MB или 512KB,
+//     по умолчанию 50MB. Проекту можно задать меньший предел командой
+//     project upload-limit;
+//   - UPLOAD_ALLOWED_TYPES — MIME типы через запятую, которые можно загружать,
+//     допускаются шаблоны вида image/*. Пусто — любые;
+//   - UPLOAD_DENIED_TYPES — типы, которые загружать нельзя;
+//   - PROJECT_STORAGE_QUOTA, USER_STORAGE_QUOTA — сколько места по умолчанию
+//     могут занять файлы проекта и файлы, загруженные пользователем, см. quota.go.
+//     Пусто или 0 — без ограничения.
+//
+// Тип определяется по содержимому файла, а не по расширению или заголовку
+// клиента. Исполняемые файлы запрещены всегда.
+
+const defaultUploadMaxSize = 50 << 20
+
+// Сколько первых байт файла нужно, чтобы определить его тип
+const sniffSize = 3072
+
+// Сколько места multipart форма занимает сверх самого файла
+const multipartOverhead = 64 << 10
+
+// Типы исполняемых файлов. Проверяются вместе с родительскими типами,
+// поэтому, например, application/x-elf закрывает и .so, и core dump.
+var executableTypes = []string{
+	"application/vnd.microsoft.portable-executable",
+	"application/x-elf",
+	"application/x-mach-binary",
+	"application/x-ms-installer",
+	"application/jar",
+	"application/vnd.android.package-archive",
+}
+
+// Скрипты по содержимому от текста не отличить, поэтому проверяем расширение
+var executableExtensions = map[string]bool{
+	".exe": true, ".com": true, ".scr": true, ".msi": true, ".dll": true,
+	".bat": true, ".cmd": true, ".ps1": true, ".vbs": true, ".sh": true,
+	".jar": true, ".apk": true,
+}
+
+type uploadPolicy struct {
+	maxSize int64
+	allowed []string
+	denied  []string
+	// Новые файлы ждут проверки на вирусы, см. scan.go
+	quarantine bool
+	// Квоты по умолчанию для проектов и пользователей без своей квоты
+	projectQuota int64
+	userQuota    int64
+}
+
+func newUploadPolicy() (uploadPolicy, error) {
+	policy := uploadPolicy{
+		maxSize: defaultUploadMaxSize,
+		allowed: mimeList(os.Getenv("UPLOAD_ALLOWED_TYPES")),
+		denied:  mimeList(os.Getenv("UPLOAD_DENIED_TYPES")),
+	}
+	if value := os.Getenv("UPLOAD_MAX_SIZE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil {
+			return policy, fmt.Errorf("UPLOAD_MAX_SIZE: %w", err)
+		}
+		policy.maxSize = size
+	}
+	for _, quota := range []struct {
+		env   string
+		value *int64
+	}{{"PROJECT_STORAGE_QUOTA", &policy.projectQuota}, {"USER_STORAGE_QUOTA", &policy.userQuota}} {
+		if value := os.Getenv(quota.env); value != "" {
+			size, err := parseSize(value)
+			if err != nil {
+				return policy, fmt.Errorf("%s: %w", quota.env, err)
+			}
+			*quota.value = size
+		}
+	}
+	return policy, nil
+}
+
+func mimeList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Размер в байтах: 1048576, 512KB, 25MB, 1GB
+func parseSize(input string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(input))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+	return size * multiplier, nil
+}
+
+// Состояние проверки, с которым сохраняется новый файл
+func (p uploadPolicy) initialScanStatus() string {
+	if p.quarantine {
+		return scanPending
+	}
+	return scanClean
+}
+
+// Предел для проекта: его собственный, если он задан, но не больше общего
+func (p uploadPolicy) limit(projectLimit int64) int64 {
+	if projectLimit > 0 && projectLimit < p.maxSize {
+		return projectLimit
+	}
+	return p.maxSize
+}
+
+// Проверяет тип, определённый по содержимому, и имя файла от клиента
+func (p uploadPolicy) check(detected *mimetype.MIME, fileName string) error {
+	if executableExtensions[strings.ToLower(filepath.Ext(fileName))] || matchesMIME(detected, executableTypes, true) {
+		return errors.New("Executable files are not allowed")
+	}
+	if matchesMIME(detected, p.denied, true) {
+		return fmt.Errorf("Files of type %s are not allowed", mediaType(detected))
+	}
+	if len(p.allowed) > 0 && !matchesMIME(detected, p.allowed, false) {
+		return fmt.Errorf("Files of type %s are not allowed", mediaType(detected))
+	}
+	return nil
+}
+
+// Совпадает ли тип с одним из шаблонов. С parents проверяются и родительские
+// типы: запрет application/zip запрещает и docx. Корень дерева
+// (application/octet-stream) не учитывается, иначе он совпал бы с любым файлом.
+func matchesMIME(detected *mimetype.MIME, patterns []string, parents bool) bool {
+	for m := detected; m != nil && (m == detected || m.Parent() != nil); m = m.Parent() {
+		for _, pattern := range patterns {
+			if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+				if strings.HasPrefix(mediaType(m), prefix+"/") {
+					return true
+				}
+			} else if m.Is(pattern) {
+				return true
+			}
+		}
+		if !parents {
+			break
+		}
+	}
+	return false
+}
+
+// Тип без параметров: text/plain вместо text/plain; charset=utf-8
+func mediaType(m *mimetype.MIME) string {
+	return contentMediaType(m.String())
+}
+
+func contentMediaType(contentType string) string {
+	value, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return value
+}
+
+// Определяет тип по началу файла. Возвращает reader, который отдаёт файл
+// целиком, вместе с уже прочитанным началом.
+func sniffUpload(file io.Reader) (io.Reader, *mimetype.MIME, error) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	head = head[:n]
+	return io.MultiReader(bytes.NewReader(head), file), mimetype.Detect(head), nil
+}
+
+func respondFileTooLarge(c *gin.Context, limit int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than the limit of %d bytes", limit), "max_size": limit})
+}
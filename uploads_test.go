@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestParseSize(t *testing.T) {
+	for input, want := range map[string]int64{
+		"1048576": 1048576,
+		"512KB":   512 << 10,
+		"25MB":    25 << 20,
+		"1GB":     1 << 30,
+		" 25 mb ": 25 << 20,
+		"5 B":     5,
+		"0":       0,
+	} {
+		got, err := parseSize(input)
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "MB", "-1", "-5KB", "1.5MB", "10TB", "ten"} {
+		if _, err := parseSize(input); err == nil {
+			t.Errorf("parseSize(%q) succeeded", input)
+		}
+	}
+}
+
+// Исполняемые файлы отклоняются всегда, остальные — по спискам типов
+func TestUploadTypeChecks(t *testing.T) {
+	elf := "\x7fELF\x02\x01\x01" + strings.Repeat("\x00", 57)
+
+	for _, tc := range []struct {
+		name    string
+		uploads uploadPolicy
+		file    string
+		content string
+		status  int
+	}{
+		{"text", uploadPolicy{}, "notes.txt", "hello", http.StatusOK},
+		{"script by extension", uploadPolicy{}, "install.sh", "echo hello", http.StatusUnsupportedMediaType},
+		{"upper case extension", uploadPolicy{}, "SETUP.EXE", "hello", http.StatusUnsupportedMediaType},
+		{"elf under another name", uploadPolicy{}, "notes.txt", elf, http.StatusUnsupportedMediaType},
+		{"denied type", uploadPolicy{denied: []string{"text/plain"}}, "notes.txt", "hello", http.StatusUnsupportedMediaType},
+		{"denied wildcard", uploadPolicy{denied: []string{"text/*"}}, "notes.txt", "hello", http.StatusUnsupportedMediaType},
+		{"not in allowed", uploadPolicy{allowed: []string{"image/*"}}, "notes.txt", "hello", http.StatusUnsupportedMediaType},
+		{"allowed", uploadPolicy{allowed: []string{"text/plain"}}, "notes.txt", "hello", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.uploads.maxSize = 1 << 20
+			srv, db := newTestServerWith(t, objectStores{files: newMemoryStore(), avatars: newMemoryStore()}, tc.uploads)
+			c := client.New(srv.URL)
+			user, project := newTestBoard(t, c)
+			ctx := context.Background()
+
+			task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.UploadTaskFile(ctx, task.ID, tc.file, strings.NewReader(tc.content))
+			status := http.StatusOK
+			if apiErr, ok := err.(*client.Error); ok {
+				status = apiErr.StatusCode
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tc.status {
+				t.Fatalf("status %d, want %d", status, tc.status)
+			}
+
+			var files int
+			if err := db.Get(&files, "SELECT COUNT(*) FROM files"); err != nil {
+				t.Fatal(err)
+			}
+			if accepted := tc.status == http.StatusOK; accepted != (files == 1) {
+				t.Errorf("%d files stored after status %d", files, status)
+			}
+		})
+	}
+}
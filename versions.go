@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Версии вложений. Каждая версия — отдельная запись в files. У первой версии
+// version_of пустой, у следующих это id первой, поэтому версии одного файла
+// выбираются по COALESCE(version_of, id) даже после удаления первой.
+// В списке файлов задачи показывается только последняя версия, остальные
+// можно скачать по их id, восстановить или удалить.
+
+const fileVersionGroup = "COALESCE(version_of, id)"
+
+var errReplacedFileNotFound = errors.New("File to replace not found in this task")
+
+// Значение поля replaces из формы или метаданных загрузки, пусто — новый файл
+func parseReplaces(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	replaces, err := strconv.Atoi(value)
+	if err != nil || replaces <= 0 {
+		return 0, fmt.Errorf("replaces must be a file id, got %q", value)
+	}
+	return replaces, nil
+}
+
+// Первая версия и номер следующей версии файла replaces задачи taskID
+func nextFileVersion(q sqlx.Queryer, taskID interface{}, replaces int) (int64, int, error) {
+	var group int64
+	var last int
+	err := q.QueryRowx("SELECT "+fileVersionGroup+", (SELECT MAX(version) FROM files versions WHERE "+versionsOf("files")+") FROM files WHERE id = $1 AND task_id = $2", replaces, taskID).
+		Scan(&group, &last)
+	if err == sql.ErrNoRows {
+		return 0, 0, errReplacedFileNotFound
+	}
+	return group, last + 1, err
+}
+
+// Условие для подзапроса с псевдонимом versions: версии того же файла, что и table
+func versionsOf(table string) string {
+	return "COALESCE(versions.version_of, versions.id) = COALESCE(" + table + ".version_of, " + table + ".id)"
+}
+
+// Проверяет replaces до загрузки, чтобы не принимать файл зря. При ошибке
+// сам отвечает клиенту и возвращает false.
+func checkReplaces(c *gin.Context, db *sqlx.DB, taskID interface{}, replaces int) bool {
+	if replaces == 0 {
+		return true
+	}
+	if _, _, err := nextFileVersion(db, taskID, replaces); err != nil {
+		if err == errReplacedFileNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		fmt.Println("error: ", err.Error())
+		return false
+	}
+	return true
+}
+
+// GET /api/v1/tasks/:id/files/:fileId/versions
+func taskFileVersionsHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query("SELECT versions.id, versions.name, versions.object_name, versions.content_type, versions.size, versions.scan_status, versions.has_thumbnails, versions.version FROM files versions JOIN files ON "+versionsOf("files")+" WHERE files.id = $1 ORDER BY versions.version DESC", file.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		versions := []File{}
+		for rows.Next() {
+			version := File{TaskID: file.TaskID}
+			var hasThumbnails bool
+			if err := rows.Scan(&version.ID, &version.Name, &version.FileUuid, &version.ContentType, &version.Size, &version.ScanStatus, &hasThumbnails, &version.Version); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				fmt.Println("error: ", err.Error())
+				return
+			}
+			version.Thumbnails = thumbnailURLs(version, hasThumbnails)
+			versions = append(versions, version)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"versions": versions})
+	})
+}
+
+// POST /api/v1/tasks/:id/files/:fileId/restore
+// Делает версию снова текущей: добавляет новую версию с тем же содержимым.
+// Объект в хранилище общий, он удалится вместе с последней ссылкой на него.
+// Новая версия записывается на того, кто её восстановил, и занимает место
+// в его квоте, а не в квоте автора исходной версии.
+func taskFileRestoreHandler(db *sqlx.DB, uploads uploadPolicy) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+
+		blob := fileBlob{objectName: file.FileUuid, existing: true}
+		var sha256 sql.NullString
+		err := db.QueryRow("SELECT has_thumbnails, sha256 FROM files WHERE id = $1", file.ID).Scan(&blob.hasThumbnails, &sha256)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+		blob.sha256 = sha256.String
+
+		restored, err := insertTaskFile(db, uploads, file, requestUploader(c), blob, file.ID)
+		if err != nil {
+			if usage, ok := err.(*quotaError); ok {
+				respondQuotaExceeded(c, usage)
+				return
+			}
+			if err == errReplacedFileNotFound || err == errBlobGone {
+				c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		respondCreated(c, fmt.Sprintf("/api/v1/tasks/%d/files/%d", restored.TaskID, restored.ID), restored)
+	})
+}
+
+// DELETE /api/v1/tasks/:id/files/:fileId/versions?keep=N
+// Удаляет старые версии файла, оставляя N последних, по умолчанию одну.
+func taskFileVersionsPruneHandler(db *sqlx.DB) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		keep := 1
+		if value := c.Query("keep"); value != "" {
+			var err error
+			keep, err = strconv.Atoi(value)
+			if err != nil || keep < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "keep must be a positive number"})
+				return
+			}
+		}
+
+		file, ok := memberTaskFile(c, db)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+
+		// Версии того же файла, у которых есть хотя бы keep более новых
+		pruned := "SELECT versions.id FROM files versions JOIN files ON " + versionsOf("files") + " WHERE files.id = $1 AND (SELECT COUNT(*) FROM files newer WHERE COALESCE(newer.version_of, newer.id) = COALESCE(versions.version_of, versions.id) AND newer.version > versions.version) >= $2"
+		n, err := deleteFiles(tx, pruned, file.ID, keep)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			fmt.Println("error: ", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deleted": n})
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"justintime-backend/api"
+	"justintime-backend/client"
+)
+
+func TestFileVersions(t *testing.T) {
+	srv, _ := newTestServer(t)
+	c := client.New(srv.URL)
+	ann, project := newTestBoard(t, c)
+	c.SetUserID(ann.ID)
+	ctx := context.Background()
+
+	bob, err := c.CreateUser(ctx, api.User{Name: "Bob", Login: "bob", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddMember(ctx, project.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	bobClient := client.New(srv.URL)
+	bobClient.SetUserID(bob.ID)
+
+	task, err := c.CreateTask(ctx, project.ID, api.Task{Name: "Task", Date: "2024-01-01", Status: "To Do", Creator_id: ann.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.UploadTaskFile(ctx, task.ID, "notes.txt", strings.NewReader("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := first
+	for _, content := range []string{"two!", "three"} {
+		if latest, err = c.UploadTaskFileVersion(ctx, task.ID, latest.ID, "notes.txt", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest.Version != 3 {
+		t.Fatalf("version after two replacements = %d, want 3", latest.Version)
+	}
+
+	versions, err := c.ListFileVersions(ctx, task.ID, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].ID != latest.ID || versions[2].ID != first.ID {
+		t.Fatalf("versions = %+v", versions)
+	}
+
+	// Восстанавливает другой участник, место занимает он
+	restored, err := bobClient.RestoreFileVersion(ctx, task.ID, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 4 || restored.Size != 3 {
+		t.Errorf("restored = %+v, want version 4 of 3 bytes", restored)
+	}
+	download, err := c.DownloadTaskFile(ctx, task.ID, restored.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(download.Body)
+	download.Body.Close()
+	if string(content) != "one" {
+		t.Errorf("restored content = %q", content)
+	}
+
+	for _, tc := range []struct {
+		user api.User
+		used int64
+	}{{ann, 3 + 4 + 5}, {bob, 3}} {
+		storage, err := c.UserStorage(ctx, tc.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if storage.Used != tc.used {
+			t.Errorf("%s uses %d bytes after restore, want %d", tc.user.Login, storage.Used, tc.used)
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/tasks/%d/files/%d/versions?keep=0", task.ID, first.ID)
+	resp := doJSON(t, srv, http.MethodDelete, path, nil, map[string]string{"X-User-ID": strconv.Itoa(ann.ID)}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("prune with keep=0: status %d, want 400", resp.StatusCode)
+	}
+
+	deleted, err := c.PruneFileVersions(ctx, task.ID, restored.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("pruned %d versions, want 2", deleted)
+	}
+	versions, err = c.ListFileVersions(ctx, task.ID, restored.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 4 || versions[1].Version != 3 {
+		t.Errorf("versions after prune = %+v", versions)
+	}
+
+	// Версия 1 удалена, но её содержимое осталось у восстановленной
+	download, err = c.DownloadTaskFile(ctx, task.ID, restored.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	download.Body.Close()
+
+	storage, err := c.UserStorage(ctx, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.Used != 5 {
+		t.Errorf("ann uses %d bytes after prune, want 5", storage.Used)
+	}
+}